@@ -0,0 +1,61 @@
+package statemate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Backup writes the entries of the store into a new, padding free, store at dstPath.
+// The entries are copied from a snapshot, so the store can be appended to while the backup is written
+// and the backup contains the entries at the time Backup was called.
+// The backup files are written next to dstPath and renamed when complete, so an interrupted
+// backup never leaves an incomplete store behind. Existing files at dstPath are not overwritten.
+// Legacy stores have to be migrated before they can be backed up.
+func (sm *StateMate[T]) Backup(ctx context.Context, dstPath string) error {
+	for _, fileName := range []string{dstPath, dstPath + ".idx", dictionaryFileName(dstPath)} {
+		exists, err := fileExists(fileName)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			return fmt.Errorf("backup file %s: %w", fileName, os.ErrExist)
+		}
+	}
+
+	snapshot, err := sm.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	defer snapshot.Release()
+
+	view := snapshot.view
+	if view.legacy {
+		return ErrLegacyFormat
+	}
+
+	newFileName := replacementFileName(dstPath)
+
+	err = view.writeFiles(ctx, newFileName, 0, view.count)
+	if err != nil {
+		return errors.Join(
+			fmt.Errorf("could not write backup: %w", err),
+			removeIfExists(newFileName),
+			removeIfExists(newFileName+".idx"),
+		)
+	}
+
+	// the dictionary is written first, so the backup is never opened without it
+	c, isDictionaryCodec := view.codec.(*dictionaryCodec)
+	if isDictionaryCodec && c.hasDictionary() {
+		err = writeDictionary(dictionaryFileName(dstPath), c.loadedDictionary())
+		if err != nil {
+			return errors.Join(err, removeIfExists(newFileName), removeIfExists(newFileName+".idx"))
+		}
+	}
+
+	return commitReplacement(dstPath)
+}
@@ -0,0 +1,86 @@
+package statemate
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrCorrupted = errors.New("corrupted")
+
+// CorruptedError describes the first inconsistent entry found in the index file.
+// It matches ErrCorrupted when used with errors.Is.
+type CorruptedError struct {
+	// Count is the number of entries stated in the index header.
+	Count uint64
+	// Position is the position of the first inconsistent entry.
+	// All entries before it are consistent.
+	Position uint64
+	Reason   string
+}
+
+func (e *CorruptedError) Error() string {
+	return fmt.Sprintf("index is corrupted at entry %d of %d: %s", e.Position, e.Count, e.Reason)
+}
+
+func (e *CorruptedError) Unwrap() error {
+	return ErrCorrupted
+}
+
+// checkIndex validates the index against the data file.
+// Indices must be strictly increasing, data offsets must not decrease and must be within the data file.
+// It returns nil when the whole index is consistent.
+func (sm *StateMate[T]) checkIndex() *CorruptedError {
+	count := sm.headerCount()
+
+	if count > sm.indexCapacity() {
+		return &CorruptedError{
+			Count:    count,
+			Position: sm.indexCapacity(),
+			Reason:   fmt.Sprintf("index file of %d bytes is too short", len(sm.index)),
+		}
+	}
+
+	lastEnd := uint64(0)
+	for pos := uint64(0); pos < count; pos++ {
+		if pos > 0 && sm.indexAt(pos) <= sm.indexAt(pos-1) {
+			return &CorruptedError{
+				Count:    count,
+				Position: pos,
+				Reason:   fmt.Sprintf("index %d is not greater than previous index %d", sm.indexAt(pos), sm.indexAt(pos-1)),
+			}
+		}
+
+		end := sm.endOf(pos)
+		if end < lastEnd {
+			return &CorruptedError{
+				Count:    count,
+				Position: pos,
+				Reason:   fmt.Sprintf("data end offset %d is before previous end offset %d", end, lastEnd),
+			}
+		}
+
+		if end > uint64(len(sm.payload())) {
+			return &CorruptedError{
+				Count:    count,
+				Position: pos,
+				Reason:   fmt.Sprintf("data end offset %d is beyond the data size %d", end, len(sm.payload())),
+			}
+		}
+
+		lastEnd = end
+	}
+
+	return nil
+}
+
+// repairIndex rolls the index back to the last consistent entry.
+func (sm *StateMate[T]) repairIndex(corrupted *CorruptedError) error {
+	sm.setHeaderCount(corrupted.Position)
+
+	err := sm.index.Flush()
+	if err != nil {
+		return fmt.Errorf("could not flush repaired index: %w", err)
+	}
+
+	return nil
+}
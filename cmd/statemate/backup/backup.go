@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/draganm/statemate"
+	"github.com/urfave/cli/v2"
+)
+
+func Command() *cli.Command {
+	cfg := struct {
+		stateFile  string
+		outputFile string
+	}{}
+
+	return &cli.Command{
+		Name:        "backup",
+		Description: "copies the entries of a state file, which can be appended to by another process, into a new truncated state file",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "state",
+				EnvVars:     []string{"STATE"},
+				Required:    true,
+				Destination: &cfg.stateFile,
+			},
+			&cli.StringFlag{
+				Name:        "output-file",
+				EnvVars:     []string{"OUTPUT_FILE"},
+				Required:    true,
+				Destination: &cfg.outputFile,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			// a follower does not lock the state file, so the writer can keep appending
+			sm, err := statemate.OpenFollower[uint64](cfg.stateFile, statemate.FollowerOptions{})
+			if err != nil {
+				return fmt.Errorf("could not open state file: %w", err)
+			}
+
+			defer sm.Close()
+
+			return sm.Backup(c.Context, cfg.outputFile)
+		},
+	}
+
+}
@@ -23,7 +23,8 @@ func Command() *cli.Command {
 			},
 		},
 		Action: func(c *cli.Context) error {
-			sm, err := statemate.Open[uint64](cfg.stateFile, statemate.Options{})
+			// a follower does not lock the state file, so stores opened by a writer can be inspected
+			sm, err := statemate.OpenFollower[uint64](cfg.stateFile, statemate.FollowerOptions{})
 			if err != nil {
 				return fmt.Errorf("could not open state file: %w", err)
 			}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"github.com/draganm/statemate/cmd/statemate/backup"
 	"github.com/draganm/statemate/cmd/statemate/info"
 	"github.com/draganm/statemate/cmd/statemate/merge"
+	"github.com/draganm/statemate/cmd/statemate/migrate"
+	"github.com/draganm/statemate/cmd/statemate/pack"
+	"github.com/draganm/statemate/cmd/statemate/sync"
+	"github.com/draganm/statemate/cmd/statemate/traindictionary"
 	"github.com/draganm/statemate/cmd/statemate/truncate"
 	"github.com/urfave/cli/v2"
 )
@@ -12,8 +17,13 @@ func main() {
 		Name:                 "statemate",
 		EnableBashCompletion: true,
 		Commands: []*cli.Command{
+			backup.Command(),
 			info.Command(),
 			merge.Command(),
+			migrate.Command(),
+			pack.Command(),
+			sync.Command(),
+			traindictionary.Command(),
 			truncate.Command(),
 		},
 	}
@@ -37,11 +37,11 @@ func Command() *cli.Command {
 		Action: func(c *cli.Context) error {
 
 			stateFilesWithError := lo.Map(cfg.stateFiles.Value(), func(stateFile string, _ int) lo.Tuple2[*statemate.StateMate[uint64], error] {
-				sm, err := statemate.Open[uint64](stateFile, statemate.Options{})
+				sm, err := statemate.OpenReadOnly[uint64](stateFile, statemate.Options{})
 				if err != nil {
-					return lo.Tuple2[*statemate.StateMate[uint64], error]{nil, fmt.Errorf("could not open state file: %w", err)}
+					return lo.Tuple2[*statemate.StateMate[uint64], error]{B: fmt.Errorf("could not open state file: %w", err)}
 				}
-				return lo.Tuple2[*statemate.StateMate[uint64], error]{sm, nil}
+				return lo.Tuple2[*statemate.StateMate[uint64], error]{A: sm}
 			})
 
 			err := lo.Reduce(stateFilesWithError, func(err error, sf lo.Tuple2[*statemate.StateMate[uint64], error], _ int) error {
@@ -70,7 +70,7 @@ func Command() *cli.Command {
 			})
 
 			ranges := lo.Map(stateFiles, func(sf *statemate.StateMate[uint64], _ int) lo.Tuple2[uint64, uint64] {
-				return lo.Tuple2[uint64, uint64]{sf.GetFirstIndex(), sf.GetLastIndex()}
+				return lo.Tuple2[uint64, uint64]{A: sf.GetFirstIndex(), B: sf.GetLastIndex()}
 			})
 
 			for i := range stateFiles[1:] {
@@ -84,15 +84,19 @@ func Command() *cli.Command {
 				return fmt.Errorf("could not open output file: %w", err)
 			}
 
-			for _, sf := range stateFiles {
-				for i := sf.GetFirstIndex(); i <= sf.GetLastIndex(); i++ {
-					err := sf.Read(i, func(data []byte) error {
-						return of.Append(i, data)
-					})
+			defer of.Close()
 
+			for _, sf := range stateFiles {
+				err := sf.Range(sf.GetFirstIndex(), sf.GetLastIndex(), func(i uint64, data []byte) error {
+					err := of.Append(i, data)
 					if err != nil {
 						return fmt.Errorf("could not write %d: %w", i, err)
 					}
+					return nil
+				})
+
+				if err != nil {
+					return err
 				}
 			}
 
@@ -0,0 +1,36 @@
+package migrate
+
+import (
+	"github.com/draganm/statemate"
+	"github.com/urfave/cli/v2"
+)
+
+func Command() *cli.Command {
+	cfg := struct {
+		stateFile string
+		checksums bool
+	}{}
+
+	return &cli.Command{
+		Name:        "migrate",
+		Description: "converts a legacy state file without headers into the current format",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "state",
+				EnvVars:     []string{"STATE"},
+				Required:    true,
+				Destination: &cfg.stateFile,
+			},
+			&cli.BoolFlag{
+				Name:        "checksums",
+				EnvVars:     []string{"CHECKSUMS"},
+				Usage:       "store checksums of the entries in the migrated state file",
+				Destination: &cfg.checksums,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			return statemate.Migrate(cfg.stateFile, statemate.Options{AllowGaps: true, Checksums: cfg.checksums})
+		},
+	}
+
+}
@@ -0,0 +1,45 @@
+package pack
+
+import (
+	"github.com/draganm/statemate"
+	"github.com/urfave/cli/v2"
+)
+
+func Command() *cli.Command {
+	cfg := struct {
+		stateFile  string
+		outputFile string
+		blockSize  int
+	}{}
+
+	return &cli.Command{
+		Name:        "pack",
+		Description: "writes the entries of a state file into a packed file compressed in blocks, to be read with OpenPacked",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "state",
+				EnvVars:     []string{"STATE"},
+				Required:    true,
+				Destination: &cfg.stateFile,
+			},
+			&cli.StringFlag{
+				Name:        "output-file",
+				EnvVars:     []string{"OUTPUT_FILE"},
+				Required:    true,
+				Destination: &cfg.outputFile,
+			},
+			&cli.IntFlag{
+				Name:        "block-size",
+				EnvVars:     []string{"BLOCK_SIZE"},
+				Value:       64 * 1024,
+				Destination: &cfg.blockSize,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			return statemate.Pack(cfg.stateFile, cfg.outputFile, statemate.PackOptions{
+				BlockSize: cfg.blockSize,
+			})
+		},
+	}
+
+}
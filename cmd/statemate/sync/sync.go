@@ -0,0 +1,77 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/draganm/statemate"
+	"github.com/urfave/cli/v2"
+)
+
+func Command() *cli.Command {
+	cfg := struct {
+		stateFile  string
+		outputFile string
+		fromIndex  uint64
+		allowGaps  bool
+	}{}
+
+	return &cli.Command{
+		Name:        "sync",
+		Description: "appends the entries of a state file following the last entry of the output state file to it",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "state",
+				EnvVars:     []string{"STATE"},
+				Required:    true,
+				Destination: &cfg.stateFile,
+			},
+			&cli.StringFlag{
+				Name:        "output-file",
+				EnvVars:     []string{"OUTPUT_FILE"},
+				Required:    true,
+				Destination: &cfg.outputFile,
+			},
+			&cli.Uint64Flag{
+				Name:        "from-index",
+				Usage:       "first index to copy when the output state file is empty",
+				EnvVars:     []string{"FROM_INDEX"},
+				Destination: &cfg.fromIndex,
+			},
+			&cli.BoolFlag{
+				Name:        "allow-gaps",
+				Usage:       "allow gaps in the indices of the output state file",
+				EnvVars:     []string{"ALLOW_GAPS"},
+				Destination: &cfg.allowGaps,
+			},
+		},
+		Action: func(c *cli.Context) (err error) {
+			// a follower does not lock the state file, so the writer can keep appending
+			sm, err := statemate.OpenFollower[uint64](cfg.stateFile, statemate.FollowerOptions{})
+			if err != nil {
+				return fmt.Errorf("could not open state file: %w", err)
+			}
+
+			defer sm.Close()
+
+			of, err := statemate.Open[uint64](cfg.outputFile, statemate.Options{AllowGaps: cfg.allowGaps})
+			if err != nil {
+				return fmt.Errorf("could not open output file: %w", err)
+			}
+
+			defer func() {
+				err = errors.Join(err, of.Close())
+			}()
+
+			copied, err := sm.CopyTo(c.Context, of, cfg.fromIndex)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("copied %d entries, last index: %d\n", copied, of.GetLastIndex())
+
+			return nil
+		},
+	}
+
+}
@@ -0,0 +1,49 @@
+package traindictionary
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/draganm/statemate"
+	"github.com/urfave/cli/v2"
+)
+
+func Command() *cli.Command {
+	cfg := struct {
+		stateFile string
+		entries   uint64
+	}{}
+
+	return &cli.Command{
+		Name:        "train-dictionary",
+		Description: "trains the compression dictionary of a state file created with the zstd dictionary codec from its first entries",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "state",
+				EnvVars:     []string{"STATE"},
+				Required:    true,
+				Destination: &cfg.stateFile,
+			},
+			&cli.Uint64Flag{
+				Name:        "entries",
+				Usage:       "number of entries to train the dictionary from",
+				EnvVars:     []string{"ENTRIES"},
+				Value:       1000,
+				Destination: &cfg.entries,
+			},
+		},
+		Action: func(c *cli.Context) (err error) {
+			sm, err := statemate.Open[uint64](cfg.stateFile, statemate.Options{})
+			if err != nil {
+				return fmt.Errorf("could not open state file: %w", err)
+			}
+
+			defer func() {
+				err = errors.Join(err, sm.Close())
+			}()
+
+			return sm.TrainDictionary(cfg.entries)
+		},
+	}
+
+}
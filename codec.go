@@ -0,0 +1,215 @@
+package statemate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec compresses the data of every entry of a store.
+// The ID of the codec is recorded in the index file header when the store is created,
+// so the store can only be opened with a codec having the same ID.
+// Codecs must be safe for concurrent use.
+type Codec interface {
+	// ID identifies the codec, IDs below 128 are reserved for the built-in codecs.
+	ID() uint8
+	// Compress appends the compressed src to dst.
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends the decompressed src to dst.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+// Built-in codecs, stores using them can be opened without setting Options.Codec.
+var (
+	Gzip   Codec = gzipCodec{}
+	Zstd   Codec = zstdCodec{}
+	Snappy Codec = snappyCodec{}
+)
+
+var builtinCodecs = map[uint8]Codec{
+	Gzip.ID():           Gzip,
+	Zstd.ID():           Zstd,
+	Snappy.ID():         Snappy,
+	ZstdDictionary.ID(): ZstdDictionary,
+}
+
+// resolveCodec returns the codec with the given ID, which is either the codec from the options
+// or one of the built-in codecs.
+// Stores using ZstdDictionary get their own codec holding the dictionary of the store.
+func resolveCodec(id uint8, dataFileName string, options Options) (Codec, error) {
+	if id == ZstdDictionary.ID() {
+		return openDictionaryCodec(dataFileName)
+	}
+
+	if options.Codec != nil && options.Codec.ID() == id {
+		return options.Codec, nil
+	}
+
+	codec, found := builtinCodecs[id]
+	if !found {
+		return nil, fmt.Errorf("codec %d: %w", id, ErrUnsupportedFormat)
+	}
+
+	return codec, nil
+}
+
+// encode compresses the data of the entries with the codec of the store.
+func (sm *StateMate[T]) encode(entries []Entry[T]) ([]Entry[T], error) {
+	if sm.codec == nil {
+		return entries, nil
+	}
+
+	encoded := make([]Entry[T], len(entries))
+	for i, e := range entries {
+		data, err := sm.codec.Compress(nil, e.Data)
+		if err != nil {
+			return nil, fmt.Errorf("could not compress entry with index %d: %w", e.Index, err)
+		}
+
+		encoded[i] = Entry[T]{Index: e.Index, Data: data}
+	}
+
+	return encoded, nil
+}
+
+// encodeData compresses the data of a single entry with the codec of the store.
+func (sm *StateMate[T]) encodeData(data []byte) ([]byte, error) {
+	if sm.codec == nil {
+		return data, nil
+	}
+
+	compressed, err := sm.codec.Compress(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("could not compress entry: %w", err)
+	}
+
+	return compressed, nil
+}
+
+// decode decrypts and decompresses the stored data of the entry with the given index into buf.
+// It returns the decoded data and the buffer to reuse for the next entry.
+func (sm *StateMate[T]) decode(index T, stored, buf []byte) ([]byte, []byte, error) {
+	dst := buf
+	if sm.hasFlag(flagEncryption) {
+		decrypted, err := sm.decrypt(index, stored, buf[:0])
+		if err != nil {
+			return nil, buf, err
+		}
+
+		// the buffer holds the decrypted data, so it can not be reused for decompressing it
+		stored, buf, dst = decrypted, decrypted, nil
+	}
+
+	if sm.codec == nil {
+		return stored, buf, nil
+	}
+
+	data, err := sm.codec.Decompress(dst[:0], stored)
+	if err != nil {
+		return nil, buf, fmt.Errorf("could not decompress entry with index %d: %w", index, err)
+	}
+
+	if dst == nil {
+		return data, buf, nil
+	}
+
+	return data, data, nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() uint8 {
+	return 1
+}
+
+func (gzipCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+
+	w := gzip.NewWriter(buf)
+
+	_, err := w.Write(src)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(dst, src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(dst)
+
+	_, err = io.Copy(buf, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), r.Close()
+}
+
+type zstdCodec struct{}
+
+// the zstd encoder and decoder are only created when the codec is used
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil)
+	})
+)
+
+func (zstdCodec) ID() uint8 {
+	return 2
+}
+
+func (zstdCodec) Compress(dst, src []byte) ([]byte, error) {
+	encoder, err := zstdEncoder()
+	if err != nil {
+		return nil, err
+	}
+
+	return encoder.EncodeAll(src, dst), nil
+}
+
+func (zstdCodec) Decompress(dst, src []byte) ([]byte, error) {
+	decoder, err := zstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+
+	return decoder.DecodeAll(src, dst)
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) ID() uint8 {
+	return 3
+}
+
+func (snappyCodec) Compress(dst, src []byte) ([]byte, error) {
+	return append(dst, snappy.Encode(nil, src)...), nil
+}
+
+func (snappyCodec) Decompress(dst, src []byte) ([]byte, error) {
+	data, err := snappy.Decode(nil, src)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(dst, data...), nil
+}
@@ -0,0 +1,64 @@
+package statemate
+
+import "errors"
+
+var ErrLastIndexMismatch = errors.New("last index does not match")
+
+// AppendNext appends the entry with the index following the last index and returns that index.
+// The index is assigned under the write lock, so concurrent callers never conflict.
+// The first entry of an empty store gets the index 0.
+func (sm *StateMate[T]) AppendNext(data []byte) (T, error) {
+	if sm.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	data, err := sm.encodeData(data)
+	if err != nil {
+		return 0, err
+	}
+
+	sm.writeMu.Lock()
+	defer sm.writeMu.Unlock()
+
+	sm.trainDictionaryIfDue()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	index := sm.lastIndex() + 1
+
+	err = sm.appendBatch([]Entry[T]{{Index: index, Data: data}})
+	if err != nil {
+		return 0, err
+	}
+
+	return index, nil
+}
+
+// AppendIfLast appends the entry only if the index of the last entry is expectedLast,
+// otherwise it returns ErrLastIndexMismatch without appending.
+// As with GetLastIndex, the last index of an empty store is math.MaxUint64.
+func (sm *StateMate[T]) AppendIfLast(expectedLast, index T, data []byte) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+
+	entries, err := sm.encode([]Entry[T]{{Index: index, Data: data}})
+	if err != nil {
+		return err
+	}
+
+	sm.writeMu.Lock()
+	defer sm.writeMu.Unlock()
+
+	sm.trainDictionaryIfDue()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.lastIndex() != expectedLast {
+		return ErrLastIndexMismatch
+	}
+
+	return sm.appendBatch(entries)
+}
@@ -0,0 +1,106 @@
+package statemate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrDiverged = errors.New("stores have diverged")
+
+// copyBatchSize is the number of data bytes after which copied entries are appended to the destination.
+const copyBatchSize = 4 * 1024 * 1024
+
+// CopyTo incrementally copies the entries of the store to dst and returns the number of copied entries.
+// Only entries with an index greater than the last index of dst are copied, or, when dst is empty,
+// entries with an index greater or equal to from.
+// Before copying, the last entry of dst is compared with the entry of this store with the same index,
+// when they differ or the entry is missing in this store, ErrDiverged is returned.
+// The entries are read from a snapshot, so the store can be appended to while copying.
+func (sm *StateMate[T]) CopyTo(ctx context.Context, dst *StateMate[T], from T) (uint64, error) {
+	snapshot, err := sm.Snapshot()
+	if err != nil {
+		return 0, err
+	}
+
+	defer snapshot.Release()
+
+	if !dst.IsEmpty() {
+		last := dst.GetLastIndex()
+
+		err = verifyEntry(snapshot, dst, last)
+		if err != nil {
+			return 0, err
+		}
+
+		if uint64(last) == math.MaxUint64 {
+			return 0, nil
+		}
+
+		from = last + 1
+	}
+
+	copied := uint64(0)
+	batch := []Entry[T]{}
+	batchSize := 0
+
+	flush := func() error {
+		err := dst.AppendBatch(batch)
+		if err != nil {
+			return fmt.Errorf("could not append to destination: %w", err)
+		}
+
+		copied += uint64(len(batch))
+		batch = batch[:0]
+		batchSize = 0
+
+		return nil
+	}
+
+	err = snapshot.Range(from, T(uint64(math.MaxUint64)), func(index T, data []byte) error {
+		batch = append(batch, Entry[T]{Index: index, Data: bytes.Clone(data)})
+		batchSize += len(data)
+
+		if batchSize < copyBatchSize {
+			return nil
+		}
+
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		return flush()
+	})
+	if err != nil {
+		return copied, err
+	}
+
+	return copied, flush()
+}
+
+// verifyEntry returns ErrDiverged unless the entries with the given index in the snapshot and dst are equal.
+func verifyEntry[T ~uint64](snapshot *Snapshot[T], dst *StateMate[T], index T) error {
+	var expected []byte
+	err := dst.Read(index, func(data []byte) error {
+		expected = bytes.Clone(data)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not read last entry %d of destination: %w", index, err)
+	}
+
+	err = snapshot.Read(index, func(data []byte) error {
+		if !bytes.Equal(data, expected) {
+			return fmt.Errorf("entry %d differs: %w", index, ErrDiverged)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("last entry %d of destination not found: %w", index, ErrDiverged)
+	}
+
+	return err
+}
@@ -0,0 +1,320 @@
+package statemate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZstdDictionary compresses entries with zstd using a dictionary trained from the entries of the store.
+// The dictionary is stored next to the data file, in a file with an additional .dict suffix.
+// Entries appended before the dictionary has been trained are compressed without the dictionary.
+// The dictionary is trained automatically after Options.DictionaryEntries entries have been appended
+// or on demand with TrainDictionary.
+var ZstdDictionary Codec = zstdDictionaryCodec{}
+
+var ErrDictionaryExists = errors.New("dictionary already exists")
+var ErrNoDictionaryCompression = errors.New("store is not compressed with ZstdDictionary")
+
+// maxDictionarySize is the maximal size of a trained dictionary.
+const maxDictionarySize = 64 * 1024
+
+func dictionaryFileName(dataFileName string) string {
+	return dataFileName + ".dict"
+}
+
+// zstdDictionaryCodec records the use of dictionaries in the header,
+// stores replace it with a dictionaryCodec holding their dictionary.
+type zstdDictionaryCodec struct {
+	zstdCodec
+}
+
+func (zstdDictionaryCodec) ID() uint8 {
+	return 4
+}
+
+// dictionaryCodec is the zstd codec of a single store using the dictionary stored next to its data file.
+type dictionaryCodec struct {
+	fileName string
+
+	mu         *sync.RWMutex
+	dictionary []byte
+	encoder    *zstd.Encoder
+	decoder    *zstd.Decoder
+}
+
+// openDictionaryCodec returns the codec of the store with the given data file,
+// loading the dictionary when it has already been trained.
+func openDictionaryCodec(dataFileName string) (*dictionaryCodec, error) {
+	c := &dictionaryCodec{
+		fileName: dictionaryFileName(dataFileName),
+		mu:       &sync.RWMutex{},
+	}
+
+	_, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *dictionaryCodec) ID() uint8 {
+	return ZstdDictionary.ID()
+}
+
+func (c *dictionaryCodec) Compress(dst, src []byte) ([]byte, error) {
+	c.mu.RLock()
+	encoder := c.encoder
+	c.mu.RUnlock()
+
+	if encoder == nil {
+		return ZstdDictionary.Compress(dst, src)
+	}
+
+	return encoder.EncodeAll(src, dst), nil
+}
+
+func (c *dictionaryCodec) Decompress(dst, src []byte) ([]byte, error) {
+	c.mu.RLock()
+	decoder := c.decoder
+	c.mu.RUnlock()
+
+	if decoder == nil {
+		data, err := ZstdDictionary.Decompress(dst, src)
+		if !errors.Is(err, zstd.ErrUnknownDictionary) {
+			return data, err
+		}
+
+		// the dictionary has been trained by another process since the store was opened
+		loaded, loadErr := c.load()
+		if loadErr != nil {
+			return nil, errors.Join(err, loadErr)
+		}
+
+		if !loaded {
+			return nil, err
+		}
+
+		return c.Decompress(dst, src)
+	}
+
+	return decoder.DecodeAll(src, dst)
+}
+
+func (c *dictionaryCodec) hasDictionary() bool {
+	return c.loadedDictionary() != nil
+}
+
+// loadedDictionary returns the dictionary or nil when it has not been trained yet.
+func (c *dictionaryCodec) loadedDictionary() []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.dictionary
+}
+
+// load reads the dictionary file and returns false when it does not exist.
+func (c *dictionaryCodec) load() (bool, error) {
+	if c.hasDictionary() {
+		return true, nil
+	}
+
+	d, err := os.ReadFile(c.fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("could not read dictionary: %w", err)
+	}
+
+	return true, c.setDictionary(d)
+}
+
+func (c *dictionaryCodec) setDictionary(d []byte) error {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(d))
+	if err != nil {
+		return fmt.Errorf("could not create encoder with dictionary: %w", err)
+	}
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(d))
+	if err != nil {
+		return fmt.Errorf("could not create decoder with dictionary: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dictionary = d
+	c.encoder = encoder
+	c.decoder = decoder
+
+	return nil
+}
+
+// writeDictionary writes the dictionary file atomically, so it is never read partially.
+func writeDictionary(fileName string, d []byte) error {
+	newFileName := fileName + ".new"
+
+	f, err := os.OpenFile(newFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0700)
+	if err != nil {
+		return fmt.Errorf("could not create dictionary file: %w", err)
+	}
+
+	_, err = f.Write(d)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		return errors.Join(fmt.Errorf("could not write dictionary file: %w", err), removeIfExists(newFileName))
+	}
+
+	err = os.Rename(newFileName, fileName)
+	if err != nil {
+		return fmt.Errorf("could not rename dictionary file: %w", err)
+	}
+
+	return syncDir(fileName)
+}
+
+// TrainDictionary trains the dictionary of a store compressed with ZstdDictionary from its first entries.
+// Only entries appended afterwards are compressed with the dictionary.
+// A store has a single dictionary, ErrDictionaryExists is returned when it has already been trained.
+func (sm *StateMate[T]) TrainDictionary(entries uint64) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+
+	sm.writeMu.Lock()
+	defer sm.writeMu.Unlock()
+
+	sm.mu.RLock()
+	closed := sm.closed
+	sm.mu.RUnlock()
+
+	if closed {
+		return ErrClosed
+	}
+
+	c, isDictionaryCodec := sm.codec.(*dictionaryCodec)
+	if !isDictionaryCodec {
+		return ErrNoDictionaryCompression
+	}
+
+	if c.hasDictionary() {
+		return ErrDictionaryExists
+	}
+
+	return sm.trainDictionary(c, entries)
+}
+
+// trainDictionary trains the dictionary from the first entries and stores it next to the data file.
+// It is called with writeMu held, the samples are copied under the read lock,
+// so readers are not blocked while the dictionary is built.
+func (sm *StateMate[T]) trainDictionary(c *dictionaryCodec, entries uint64) error {
+	samples, err := sm.dictionarySamples(entries)
+	if err != nil {
+		return err
+	}
+
+	trainable := false
+	for _, data := range samples {
+		// samples are indexed in chunks of 8 bytes
+		trainable = trainable || len(data) >= 8
+	}
+
+	if !trainable {
+		return fmt.Errorf("could not train dictionary: not enough data in %d entries", len(samples))
+	}
+
+	d, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: maxDictionarySize,
+		HashBytes:   6,
+		// entries are compressed with the default level
+		ZstdLevel: zstd.SpeedDefault,
+	})
+	if err != nil {
+		return fmt.Errorf("could not train dictionary: %w", err)
+	}
+
+	err = writeDictionary(c.fileName, d)
+	if err != nil {
+		return err
+	}
+
+	return c.setDictionary(d)
+}
+
+// dictionarySamples returns the decompressed data of the first entries.
+func (sm *StateMate[T]) dictionarySamples(entries uint64) ([][]byte, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if sm.closed {
+		return nil, ErrClosed
+	}
+
+	samples := [][]byte{}
+	for pos := uint64(0); pos < min(entries, sm.count); pos++ {
+		stored, err := sm.entryData(pos)
+		if err != nil {
+			return nil, err
+		}
+
+		// the codec decompresses into a new slice, so the sample does not refer to the mapping
+		data, _, err := sm.decode(sm.indexAt(pos), stored, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, data)
+	}
+
+	return samples, nil
+}
+
+// trainDictionaryIfDue trains the dictionary once the store has Options.DictionaryEntries entries.
+// It is called with writeMu held before the appended entries are written.
+// A failed training does not fail the append: the error is kept for DictionaryError,
+// entries are compressed without the dictionary and training is retried with twice as many entries.
+func (sm *StateMate[T]) trainDictionaryIfDue() {
+	c, isDictionaryCodec := sm.codec.(*dictionaryCodec)
+	if !isDictionaryCodec || sm.options.DictionaryEntries == 0 || c.hasDictionary() {
+		return
+	}
+
+	sm.mu.RLock()
+	count := sm.count
+	sm.mu.RUnlock()
+
+	due := max(sm.options.DictionaryEntries, sm.dictionaryRetryAt)
+	if count < due {
+		return
+	}
+
+	err := sm.trainDictionary(c, due)
+	if err != nil {
+		sm.dictionaryRetryAt = due * 2
+	}
+
+	sm.mu.Lock()
+	sm.dictionaryErr = err
+	sm.mu.Unlock()
+}
+
+// DictionaryError returns the error of the last automatic training of the dictionary,
+// which is retried while entries are appended, see Options.DictionaryEntries.
+// It returns nil when the dictionary has been trained or training has not been attempted yet.
+func (sm *StateMate[T]) DictionaryError() error {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return sm.dictionaryErr
+}
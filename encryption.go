@@ -0,0 +1,215 @@
+package statemate
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrNoCipher = errors.New("store is encrypted, Options.Cipher is not set")
+var ErrNotEncrypted = errors.New("store is not encrypted")
+var ErrDecryptionFailed = errors.New("decryption failed")
+
+// The data of an encrypted entry is prefixed with the random ID of the key it was encrypted with
+// and the nonce is the index of the entry padded with zeros.
+// A writer chooses a new key ID when it is opened and before an index could be encrypted again with the same key,
+// which happens after TruncateAfter, Tx.Rollback or a failed append.
+// Stores sharing a key and copies of a store therefore never encrypt with the same key and nonce.
+const (
+	keyIDSize = 16
+	nonceSize = 12
+
+	// maxCachedKeys is the number of ciphers kept for reading, which is only exceeded by stores
+	// with entries encrypted by a large number of writers.
+	maxCachedKeys = 64
+)
+
+// Cipher returns the authenticated ciphers encrypting the entries of a store, see NewAESGCM.
+type Cipher interface {
+	// AEAD returns the cipher for the given key ID, which has to use the same key for the same ID
+	// and different keys for different IDs. The nonce size of the cipher has to be 12 bytes.
+	AEAD(keyID []byte) (cipher.AEAD, error)
+}
+
+type aesGCM struct {
+	key []byte
+}
+
+// NewAESGCM returns an AES-GCM cipher for Options.Cipher.
+// The key has to be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+// The entries are encrypted with keys derived from the key and the key ID with HKDF-SHA256.
+func NewAESGCM(key []byte) (Cipher, error) {
+	_, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create AES cipher: %w", err)
+	}
+
+	return aesGCM{key: bytes.Clone(key)}, nil
+}
+
+func (c aesGCM) AEAD(keyID []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(c.key, keyID))
+	if err != nil {
+		return nil, fmt.Errorf("could not create AES cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// deriveKey derives a key with the length of key for the key ID using HKDF-SHA256 (RFC 5869),
+// the key ID is the salt and a single block of output is expanded.
+func deriveKey(key, keyID []byte) []byte {
+	extract := hmac.New(sha256.New, keyID)
+	extract.Write(key)
+
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte("statemate entry key"))
+	expand.Write([]byte{1})
+
+	return expand.Sum(nil)[:len(key)]
+}
+
+// keyring caches the ciphers of the key IDs of a store, it is shared with the snapshots of the store.
+type keyring struct {
+	cipher Cipher
+
+	mu    *sync.Mutex
+	aeads map[string]cipher.AEAD
+}
+
+func newKeyring(c Cipher) *keyring {
+	if c == nil {
+		return nil
+	}
+
+	return &keyring{
+		cipher: c,
+		mu:     &sync.Mutex{},
+		aeads:  map[string]cipher.AEAD{},
+	}
+}
+
+func (k *keyring) aead(keyID []byte) (cipher.AEAD, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	aead, found := k.aeads[string(keyID)]
+	if found {
+		return aead, nil
+	}
+
+	aead, err := k.cipher.AEAD(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+
+	if aead.NonceSize() != nonceSize {
+		return nil, fmt.Errorf("cipher nonce size must be %d bytes, got %d", nonceSize, aead.NonceSize())
+	}
+
+	if len(k.aeads) >= maxCachedKeys {
+		clear(k.aeads)
+	}
+
+	k.aeads[string(keyID)] = aead
+
+	return aead, nil
+}
+
+// checkCipherOptions verifies that the cipher can be used with the other options, before any file is opened.
+func checkCipherOptions(options Options) error {
+	// the dictionary is trained from the entries and would be stored unencrypted
+	if options.Cipher != nil && options.Codec != nil && options.Codec.ID() == ZstdDictionary.ID() {
+		return fmt.Errorf("ZstdDictionary can not be used with encryption: %w", ErrUnsupportedFormat)
+	}
+
+	return nil
+}
+
+// checkCipher verifies that a store having the layout is encrypted when the options have a cipher.
+func checkCipher(l layout, options Options) error {
+	if options.Cipher != nil && !l.hasFlag(flagEncryption) {
+		return ErrNotEncrypted
+	}
+
+	return nil
+}
+
+func nonce[T ~uint64](index T) []byte {
+	n := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(n, uint64(index))
+	return n
+}
+
+// encrypt encrypts the data of the entries.
+// It is called with the write lock held, so the key ID and the last encrypted index are not changed concurrently.
+func (sm *StateMate[T]) encrypt(entries []Entry[T]) ([]Entry[T], error) {
+	if !sm.hasFlag(flagEncryption) {
+		return entries, nil
+	}
+
+	if sm.keys == nil {
+		return nil, ErrNoCipher
+	}
+
+	encrypted := make([]Entry[T], len(entries))
+	for i, e := range entries {
+		// the index may have been encrypted with the current key before
+		if sm.keyID == nil || e.Index <= sm.lastEncrypted {
+			keyID := make([]byte, keyIDSize)
+			_, err := rand.Read(keyID)
+			if err != nil {
+				return nil, fmt.Errorf("could not generate key ID: %w", err)
+			}
+
+			sm.keyID = keyID
+		}
+
+		aead, err := sm.keys.aead(sm.keyID)
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, keyIDSize, keyIDSize+len(e.Data)+aead.Overhead())
+		copy(data, sm.keyID)
+
+		encrypted[i] = Entry[T]{
+			Index: e.Index,
+			Data:  aead.Seal(data, nonce(e.Index), e.Data, nil),
+		}
+
+		sm.lastEncrypted = e.Index
+	}
+
+	return encrypted, nil
+}
+
+// decrypt appends the decrypted stored data of the entry with the given index to dst.
+func (sm *StateMate[T]) decrypt(index T, stored, dst []byte) ([]byte, error) {
+	if sm.keys == nil {
+		return nil, ErrNoCipher
+	}
+
+	if len(stored) < keyIDSize {
+		return nil, fmt.Errorf("entry with index %d: %w", index, ErrDecryptionFailed)
+	}
+
+	aead, err := sm.keys.aead(stored[:keyIDSize])
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := aead.Open(dst, nonce(index), stored[keyIDSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("entry with index %d: %w", index, ErrDecryptionFailed)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,192 @@
+package statemate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+const defaultPollInterval = 100 * time.Millisecond
+
+type FollowerOptions struct {
+	// Options are used to open the store, only MaxSize is relevant.
+	Options
+
+	// PollInterval is the interval in which the count in the index header is checked for new entries.
+	// Zero means 100ms.
+	PollInterval time.Duration
+}
+
+// OpenFollower opens an existing store read-only and follows the entries appended by a writer,
+// which can be in a different process on the same host.
+// The follower polls the count in the index header, remaps the files when they grow
+// and reopens them when they are replaced by PruneBefore or Migrate.
+// New entries are visible to Read, Range, the iterators, WaitFor and Subscribe.
+//
+// Only entries persisted in the index header are visible, so with deferred sync modes
+// the writer's entries appear after its next sync.
+// The writer must not shrink the files with TruncateAfter followed by Truncate while followers are reading,
+// because a follower may access removed entries until its next poll.
+// Methods modifying the store return ErrReadOnly.
+func OpenFollower[T ~uint64](dataFileName string, options FollowerOptions) (*StateMate[T], error) {
+	err := checkCipherOptions(options.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	dataFile, err := os.Open(dataFileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %w", err)
+	}
+
+	indexFile, err := os.Open(dataFileName + ".idx")
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("could not open file: %w", err), dataFile.Close())
+	}
+
+	sm, err := open[T](dataFile, indexFile, options.Options, true)
+	if err != nil {
+		return nil, errors.Join(err, dataFile.Close(), indexFile.Close())
+	}
+
+	sm.dataFileName = dataFileName
+	sm.stopFollowing = make(chan struct{})
+	sm.truncations, _ = sm.headerTruncations()
+
+	pollInterval := options.PollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	go sm.follow(pollInterval, sm.stopFollowing)
+
+	return sm, nil
+}
+
+func (sm *StateMate[T]) follow(pollInterval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		sm.mu.Lock()
+		if !sm.closed {
+			// errors are transient while the writer is replacing or growing the files,
+			// so polling is simply retried on the next tick
+			_ = sm.poll()
+		}
+		sm.mu.Unlock()
+	}
+}
+
+// poll makes the entries persisted by the writer since the last poll visible.
+func (sm *StateMate[T]) poll() error {
+	replaced, err := sm.filesReplaced()
+	if err != nil {
+		return err
+	}
+
+	if replaced {
+		err = sm.reopen(nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the truncation is read before the count, which the writer updates after recording it
+	truncations, truncated := sm.headerTruncations()
+	count := sm.headerCount()
+
+	if count > sm.indexCapacity() {
+		sm.index, err = sm.remap(sm.index, sm.indexFile)
+		if err != nil {
+			return err
+		}
+
+		if count > sm.indexCapacity() {
+			return fmt.Errorf("index file is shorter than %d entries", count)
+		}
+	}
+
+	if count > 0 && sm.endOf(count-1) > uint64(len(sm.payload())) {
+		sm.data, err = sm.remap(sm.data, sm.dataFile)
+		if err != nil {
+			return err
+		}
+
+		if sm.endOf(count-1) > uint64(len(sm.payload())) {
+			return fmt.Errorf("data file is shorter than %d entries", count)
+		}
+	}
+
+	// the writer removed entries with TruncateAfter, possibly appending entries with the same indices again.
+	// The files written by PruneBefore start without truncations.
+	previous := sm.truncations
+	if replaced {
+		previous = 0
+	}
+
+	switch {
+	case truncations == previous+1:
+		sm.truncatedSubscribers(min(truncated, count))
+	case truncations != previous:
+		// the entries left by earlier truncations are unknown
+		sm.truncatedSubscribers(0)
+	case count < sm.count:
+		sm.truncatedSubscribers(count)
+	}
+
+	sm.truncations = truncations
+
+	if replaced || count != sm.count {
+		sm.count = count
+		sm.persistedCount = count
+		sm.notifyAppended()
+	}
+
+	return nil
+}
+
+// filesReplaced returns true when both the data and the index file have been replaced by new files.
+func (sm *StateMate[T]) filesReplaced() (bool, error) {
+	for _, f := range []*os.File{sm.dataFile, sm.indexFile} {
+		opened, err := f.Stat()
+		if err != nil {
+			return false, fmt.Errorf("could not stat file: %w", err)
+		}
+
+		current, err := os.Stat(f.Name())
+		if err != nil {
+			return false, fmt.Errorf("could not stat file: %w", err)
+		}
+
+		if os.SameFile(opened, current) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// remap replaces the read-only mapping m of the file f with a mapping of the whole file.
+func (sm *StateMate[T]) remap(m mmap.MMap, f *os.File) (mmap.MMap, error) {
+	remapped, err := mmap.Map(f, mmap.RDONLY, 0)
+	if err != nil {
+		return m, fmt.Errorf("could not remap file: %w", err)
+	}
+
+	err = sm.unmap(m)
+	if err != nil {
+		return remapped, fmt.Errorf("could not unmap file: %w", err)
+	}
+
+	return remapped, nil
+}
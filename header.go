@@ -0,0 +1,227 @@
+package statemate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Layout of the index file header:
+//
+//	0  magic       4 bytes "SMIX"
+//	4  version     uint16
+//	6  key width   uint8
+//	7  reserved    uint8
+//	8  flags       uint64
+//	16 count       uint64
+//	24 codec       uint8
+//	25 reserved    7 bytes
+//	32 truncations uint64
+//	40 truncated   uint64
+//	48 reserved    16 bytes
+//
+// Layout of the data file header:
+//
+//	0  magic       4 bytes "SMDT"
+//	4  version     uint16
+//	6  reserved    10 bytes
+//
+// Stores created before headers were introduced (legacy stores) have an index file
+// starting directly with the count and a data file without a header.
+const (
+	indexMagic = "SMIX"
+	dataMagic  = "SMDT"
+
+	formatVersion = 1
+	keyWidth      = 8
+
+	indexFileHeaderSize = 64
+	dataFileHeaderSize  = 16
+
+	legacyIndexHeaderSize = 8
+
+	codecOffset = 24
+	// truncationsOffset is the number of TruncateAfter calls removing entries
+	// and truncatedOffset the number of entries left by the last one, so followers can detect rewritten entries.
+	truncationsOffset = 32
+	truncatedOffset   = 40
+)
+
+// Feature flags stored in the index file header.
+const (
+	flagChecksums uint64 = 1 << iota
+	// flagCompression marks stores with compressed entries, the codec is stored in the header.
+	flagCompression
+	// flagEncryption marks stores with encrypted entries, see Options.Cipher.
+	flagEncryption
+
+	knownFlags = flagChecksums | flagCompression | flagEncryption
+)
+
+var ErrInvalidFormat = errors.New("not a statemate store")
+var ErrUnsupportedFormat = errors.New("unsupported store format")
+
+// layout describes where headers, records and data are located in the files of a store.
+type layout struct {
+	legacy bool
+	flags  uint64
+	// codecID is the ID of the codec compressing the entries when flagCompression is set.
+	codecID uint8
+
+	indexHeaderSize uint64
+	dataHeaderSize  uint64
+	countOffset     uint64
+
+	// recordSize is the size of a single record in the index file.
+	recordSize uint64
+}
+
+func (l layout) hasFlag(flag uint64) bool {
+	return l.flags&flag != 0
+}
+
+func newLayout(flags uint64, codecID uint8) layout {
+	l := layout{
+		flags:           flags,
+		codecID:         codecID,
+		indexHeaderSize: indexFileHeaderSize,
+		dataHeaderSize:  dataFileHeaderSize,
+		countOffset:     16,
+		recordSize:      indexRecordSize,
+	}
+	if l.hasFlag(flagChecksums) {
+		l.recordSize = checksummedRecordSize
+	}
+	return l
+}
+
+// legacyLayout returns the layout of stores without headers, which never have checksums.
+// It does not depend on the options, because opening a store with a wrong record size
+// would make the consistency check and Repair discard its entries.
+func legacyLayout() layout {
+	return layout{
+		legacy:          true,
+		indexHeaderSize: legacyIndexHeaderSize,
+		recordSize:      indexRecordSize,
+	}
+}
+
+// optionsLayout returns the layout of a new store created with the options.
+func optionsLayout(options Options) layout {
+	flags := uint64(0)
+	if options.Checksums {
+		flags |= flagChecksums
+	}
+
+	codecID := uint8(0)
+	if options.Codec != nil {
+		flags |= flagCompression
+		codecID = options.Codec.ID()
+	}
+
+	if options.Cipher != nil {
+		flags |= flagEncryption
+	}
+
+	return newLayout(flags, codecID)
+}
+
+func indexFileHeader(l layout) []byte {
+	h := make([]byte, indexFileHeaderSize)
+	copy(h, indexMagic)
+	binary.BigEndian.PutUint16(h[4:], formatVersion)
+	h[6] = keyWidth
+	binary.BigEndian.PutUint64(h[8:], l.flags)
+	h[codecOffset] = l.codecID
+	return h
+}
+
+func dataFileHeader() []byte {
+	h := make([]byte, dataFileHeaderSize)
+	copy(h, dataMagic)
+	binary.BigEndian.PutUint16(h[4:], formatVersion)
+	return h
+}
+
+// initFiles writes the headers of a new store.
+func initFiles(dataFile, indexFile *os.File, l layout) error {
+	_, err := dataFile.WriteAt(dataFileHeader(), 0)
+	if err != nil {
+		return fmt.Errorf("could not write data file header: %w", err)
+	}
+
+	_, err = indexFile.WriteAt(indexFileHeader(l), 0)
+	if err != nil {
+		return fmt.Errorf("could not write index file header: %w", err)
+	}
+
+	return nil
+}
+
+// readHeader reads up to size bytes from the beginning of the file.
+func readHeader(f *os.File, size int) ([]byte, error) {
+	h := make([]byte, size)
+	n, err := f.ReadAt(h, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return h[:n], nil
+}
+
+// readLayout determines the layout of an existing store from the headers of its files.
+func readLayout(dataFile, indexFile *os.File) (layout, error) {
+	indexHeader, err := readHeader(indexFile, indexFileHeaderSize)
+	if err != nil {
+		return layout{}, fmt.Errorf("could not read index file header: %w", err)
+	}
+
+	if !bytes.HasPrefix(indexHeader, []byte(indexMagic)) {
+		fi, err := indexFile.Stat()
+		if err != nil {
+			return layout{}, fmt.Errorf("could not stat file: %w", err)
+		}
+
+		if fi.Size() < legacyIndexHeaderSize {
+			return layout{}, fmt.Errorf("index file is too short: %w", ErrInvalidFormat)
+		}
+
+		return legacyLayout(), nil
+	}
+
+	if len(indexHeader) < indexFileHeaderSize {
+		return layout{}, fmt.Errorf("index file header is truncated: %w", ErrInvalidFormat)
+	}
+
+	version := binary.BigEndian.Uint16(indexHeader[4:])
+	if version > formatVersion {
+		return layout{}, fmt.Errorf("format version %d: %w", version, ErrUnsupportedFormat)
+	}
+
+	if indexHeader[6] != keyWidth {
+		return layout{}, fmt.Errorf("key width %d: %w", indexHeader[6], ErrUnsupportedFormat)
+	}
+
+	flags := binary.BigEndian.Uint64(indexHeader[8:])
+	if flags&^knownFlags != 0 {
+		return layout{}, fmt.Errorf("feature flags %x: %w", flags, ErrUnsupportedFormat)
+	}
+
+	dataHeader, err := readHeader(dataFile, dataFileHeaderSize)
+	if err != nil {
+		return layout{}, fmt.Errorf("could not read data file header: %w", err)
+	}
+
+	if len(dataHeader) < dataFileHeaderSize || !bytes.HasPrefix(dataHeader, []byte(dataMagic)) {
+		return layout{}, fmt.Errorf("data file has no header: %w", ErrInvalidFormat)
+	}
+
+	codecID := uint8(0)
+	if flags&flagCompression != 0 {
+		codecID = indexHeader[codecOffset]
+	}
+
+	return newLayout(flags, codecID), nil
+}
@@ -0,0 +1,143 @@
+package statemate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// The index file starts with a header holding the number of entries, followed by one record per entry.
+// Each record holds the index of the entry and the offset of the end of its data in the data file,
+// optionally followed by the CRC32C checksum of the data.
+const (
+	indexHeaderSize       = 8
+	indexRecordSize       = 16
+	checksummedRecordSize = indexRecordSize + 4
+)
+
+// indexSize returns the number of bytes used by the index file holding count entries.
+func (sm *StateMate[T]) indexSize(count uint64) uint64 {
+	return sm.indexHeaderSize + count*sm.recordSize
+}
+
+// indexCapacity returns the number of records fitting into the index mapping.
+// Counts read from disk are compared with it instead of calling indexSize, which can overflow.
+func (sm *StateMate[T]) indexCapacity() uint64 {
+	if uint64(len(sm.index)) < sm.indexHeaderSize {
+		return 0
+	}
+
+	return (uint64(len(sm.index)) - sm.indexHeaderSize) / sm.recordSize
+}
+
+// headerTruncations returns the number of truncations recorded in the header of the index file
+// and the number of entries left by the last one. Legacy stores do not record truncations.
+func (sm *StateMate[T]) headerTruncations() (uint64, uint64) {
+	if sm.legacy {
+		return 0, 0
+	}
+
+	return binary.BigEndian.Uint64(sm.index[truncationsOffset:]), binary.BigEndian.Uint64(sm.index[truncatedOffset:])
+}
+
+// recordTruncation records in the header of the index file that entries after the first count entries were removed.
+func (sm *StateMate[T]) recordTruncation(count uint64) {
+	if sm.legacy {
+		return
+	}
+
+	truncations, _ := sm.headerTruncations()
+	binary.BigEndian.PutUint64(sm.index[truncationsOffset:], truncations+1)
+	binary.BigEndian.PutUint64(sm.index[truncatedOffset:], count)
+}
+
+// headerCount returns the number of entries stored in the header of the index file.
+func (sm *StateMate[T]) headerCount() uint64 {
+	return binary.BigEndian.Uint64(sm.index[sm.countOffset:])
+}
+
+func (sm *StateMate[T]) setHeaderCount(count uint64) {
+	binary.BigEndian.PutUint64(sm.index[sm.countOffset:], count)
+}
+
+func (sm *StateMate[T]) record(pos uint64) []byte {
+	return sm.index[sm.indexSize(pos):sm.indexSize(pos+1)]
+}
+
+func (sm *StateMate[T]) putRecord(pos uint64, index T, end uint64, data []byte) {
+	r := sm.record(pos)
+	binary.BigEndian.PutUint64(r, uint64(index))
+	binary.BigEndian.PutUint64(r[8:], end)
+	if sm.hasFlag(flagChecksums) {
+		binary.BigEndian.PutUint32(r[16:], crc32.Checksum(data, castagnoli))
+	}
+}
+
+// indexAt returns the index of the entry at position pos.
+func (sm *StateMate[T]) indexAt(pos uint64) T {
+	return T(binary.BigEndian.Uint64(sm.record(pos)))
+}
+
+// endOf returns the offset of the end of data of the entry at position pos.
+func (sm *StateMate[T]) endOf(pos uint64) uint64 {
+	return binary.BigEndian.Uint64(sm.record(pos)[8:])
+}
+
+// entryData returns the data of the entry at position pos.
+// When checksums are enabled the data is verified against the checksum stored in the index.
+func (sm *StateMate[T]) entryData(pos uint64) ([]byte, error) {
+	data := sm.rawData(pos)
+	if sm.hasFlag(flagChecksums) {
+		expected := binary.BigEndian.Uint32(sm.record(pos)[16:])
+		if crc32.Checksum(data, castagnoli) != expected {
+			return nil, fmt.Errorf("entry with index %d: %w", sm.indexAt(pos), ErrChecksumMismatch)
+		}
+	}
+	return data, nil
+}
+
+// payload returns the part of the data file following the header.
+// Data offsets stored in the index are relative to the start of the payload.
+func (sm *StateMate[T]) payload() []byte {
+	return sm.data[sm.dataHeaderSize:]
+}
+
+// rawData returns the data of the entry at position pos as stored in the data file.
+func (sm *StateMate[T]) rawData(pos uint64) []byte {
+	return sm.payload()[sm.dataStart(pos):sm.endOf(pos)]
+}
+
+// dataStart returns the offset of the start of data of the entry at position pos.
+func (sm *StateMate[T]) dataStart(pos uint64) uint64 {
+	if pos == 0 {
+		return 0
+	}
+	return sm.endOf(pos - 1)
+}
+
+// dataEnd returns the offset of the end of data of the last entry.
+func (sm *StateMate[T]) dataEnd() uint64 {
+	return sm.dataStart(sm.count)
+}
+
+// search returns the position of the first entry with an index greater or equal to index.
+func (sm *StateMate[T]) search(index T) uint64 {
+	return uint64(sort.Search(int(sm.count), func(i int) bool {
+		return sm.indexAt(uint64(i)) >= index
+	}))
+}
+
+// find returns the position of the entry with the given index.
+func (sm *StateMate[T]) find(index T) (uint64, bool) {
+	pos := sm.search(index)
+	if pos == sm.count || sm.indexAt(pos) != index {
+		return 0, false
+	}
+	return pos, true
+}
@@ -0,0 +1,107 @@
+package statemate
+
+import (
+	"iter"
+	"math"
+)
+
+// All returns an iterator over all entries in ascending index order.
+// The read lock is held while iterating, so the loop body must not call Append or Truncate.
+// Iteration stops at entries failing checksum verification, decryption or decompression,
+// use Entries to get the error.
+func (sm *StateMate[T]) All() iter.Seq2[T, []byte] {
+	return sm.Between(0, T(uint64(math.MaxUint64)))
+}
+
+// Between returns an iterator over the entries with an index between from and to (both inclusive),
+// in ascending index order.
+// The read lock is held while iterating, so the loop body must not call Append or Truncate.
+// Iteration stops at entries failing checksum verification, decryption or decompression,
+// use Entries to get the error.
+func (sm *StateMate[T]) Between(from, to T) iter.Seq2[T, []byte] {
+	return func(yield func(T, []byte) bool) {
+		for e, err := range sm.Entries(from, to) {
+			if err != nil || !yield(e.Index, e.Data) {
+				return
+			}
+		}
+	}
+}
+
+// Entries returns an iterator over the entries with an index between from and to (both inclusive),
+// in ascending index order, like Between.
+// When an entry can not be read, its index is yielded with the error, for example ErrChecksumMismatch,
+// and the iteration ends. Iterating over a closed store yields ErrClosed.
+// The data of an entry is only valid until the next iteration.
+// The read lock is held while iterating, so the loop body must not call Append or Truncate.
+func (sm *StateMate[T]) Entries(from, to T) iter.Seq2[Entry[T], error] {
+	return func(yield func(Entry[T], error) bool) {
+		sm.mu.RLock()
+		defer sm.mu.RUnlock()
+
+		if sm.closed {
+			yield(Entry[T]{}, ErrClosed)
+			return
+		}
+
+		var buf []byte
+		for pos := sm.search(from); pos < sm.count; pos++ {
+			index := sm.indexAt(pos)
+			if index > to {
+				return
+			}
+
+			var data []byte
+			var err error
+			data, buf, err = sm.iteratedData(pos, buf)
+			if err != nil {
+				yield(Entry[T]{Index: index}, err)
+				return
+			}
+
+			if !yield(Entry[T]{Index: index, Data: data}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over all entries in descending index order.
+// The read lock is held while iterating, so the loop body must not call Append or Truncate.
+// Iteration stops at entries failing checksum verification, decryption or decompression.
+func (sm *StateMate[T]) Backward() iter.Seq2[T, []byte] {
+	return func(yield func(T, []byte) bool) {
+		sm.mu.RLock()
+		defer sm.mu.RUnlock()
+
+		if sm.closed {
+			return
+		}
+
+		var buf []byte
+		for pos := sm.count; pos > 0; pos-- {
+			index := sm.indexAt(pos - 1)
+
+			var data []byte
+			var err error
+			data, buf, err = sm.iteratedData(pos-1, buf)
+			if err != nil {
+				return
+			}
+
+			if !yield(index, data) {
+				return
+			}
+		}
+	}
+}
+
+// iteratedData returns the verified and decoded data of the entry at position pos, see decode.
+func (sm *StateMate[T]) iteratedData(pos uint64, buf []byte) ([]byte, []byte, error) {
+	stored, err := sm.entryData(pos)
+	if err != nil {
+		return nil, buf, err
+	}
+
+	return sm.decode(sm.indexAt(pos), stored, buf)
+}
@@ -0,0 +1,74 @@
+package statemate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var ErrLocked = errors.New("store is locked by another process")
+
+const lockRetryInterval = 10 * time.Millisecond
+
+// lock takes an advisory lock on the file.
+// When the lock is held by another process, it is retried until the timeout expires and ErrLocked is returned.
+func lock(f *os.File, exclusive bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		locked, err := tryLock(f, exclusive)
+		if err != nil {
+			return fmt.Errorf("could not lock %s: %w", f.Name(), err)
+		}
+
+		if locked {
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%s: %w", f.Name(), ErrLocked)
+		}
+
+		time.Sleep(min(lockRetryInterval, time.Until(deadline)))
+	}
+}
+
+// openLocked opens the data file and locks it, exclusively when it is opened for writing.
+// The data file of a locked store is only replaced by the lock holder, so when the file
+// has been replaced while waiting for the lock, the new file is opened and locked instead.
+func openLocked(dataFileName string, flag int, timeout time.Duration) (*os.File, error) {
+	deadline := time.Now().Add(timeout)
+	exclusive := flag&os.O_RDWR != 0
+
+	for {
+		f, err := os.OpenFile(dataFileName, flag, 0700)
+		if err != nil {
+			return nil, fmt.Errorf("could not open file: %w", err)
+		}
+
+		err = lock(f, exclusive, time.Until(deadline))
+		if err != nil {
+			return nil, errors.Join(err, f.Close())
+		}
+
+		opened, err := f.Stat()
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("could not stat file: %w", err), f.Close())
+		}
+
+		current, err := os.Stat(dataFileName)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Join(fmt.Errorf("could not stat file: %w", err), f.Close())
+		}
+
+		if err == nil && os.SameFile(opened, current) {
+			return f, nil
+		}
+
+		err = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not close replaced file: %w", err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package statemate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Migrate converts a legacy store without file headers into the current format.
+// The store is locked while it is migrated, so it can not be opened by another writer.
+// The options are used to create the migrated store, for example to enable checksums.
+// Stores that already have headers are left unchanged.
+//
+// The migrated store is written next to the original one and renamed over it.
+func Migrate(dataFileName string, options Options) error {
+	_, err := os.Stat(dataFileName)
+	if err != nil {
+		return fmt.Errorf("could not stat data file: %w", err)
+	}
+
+	// legacy stores are not encrypted, the cipher is only used for the migrated store
+	legacyOptions := options
+	legacyOptions.Cipher = nil
+
+	legacy, err := Open[uint64](dataFileName, legacyOptions)
+	if err != nil {
+		return fmt.Errorf("could not open store: %w", err)
+	}
+
+	defer legacy.Close()
+
+	if !legacy.legacy {
+		return nil
+	}
+
+	migrated, err := Open[uint64](replacementFileName(dataFileName), Options{
+		AllowGaps: true,
+		Checksums: options.Checksums,
+		Codec:     options.Codec,
+		Cipher:    options.Cipher,
+		Sync:      SyncManual,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create migrated store: %w", err)
+	}
+
+	err = legacy.Range(0, math.MaxUint64, migrated.Append)
+	if err != nil {
+		return errors.Join(fmt.Errorf("could not copy entries: %w", err), migrated.Close())
+	}
+
+	err = migrated.Truncate()
+	if err != nil {
+		return errors.Join(fmt.Errorf("could not truncate migrated store: %w", err), migrated.Close())
+	}
+
+	err = migrated.Close()
+	if err != nil {
+		return fmt.Errorf("could not close migrated store: %w", err)
+	}
+
+	// the legacy store stays locked until its files have been replaced
+	err = commitReplacement(dataFileName)
+	if err != nil {
+		return err
+	}
+
+	err = legacy.Close()
+	if err != nil {
+		return fmt.Errorf("could not close store: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package statemate
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+)
+
+var ErrTruncated = errors.New("delivered entries have been removed")
+
+// subscriptionBatchSize is the maximal number of entries a subscription copies under a single read lock.
+const subscriptionBatchSize = 1024
+
+// notifyAppended wakes up all goroutines waiting for new entries.
+func (sm *StateMate[T]) notifyAppended() {
+	close(sm.appended)
+	sm.appended = make(chan struct{})
+}
+
+// WaitFor blocks until the store contains an entry with an index greater or equal to index.
+// It returns the error of the context when it is done before such entry is appended
+// and ErrClosed when the store is closed.
+func (sm *StateMate[T]) WaitFor(ctx context.Context, index T) error {
+	return sm.waitFor(ctx, nil, index)
+}
+
+// waitFor is WaitFor returning ErrTruncated when the subscriber, if not nil, is ended by TruncateAfter.
+func (sm *StateMate[T]) waitFor(ctx context.Context, sub *subscriber[T], index T) error {
+	for {
+		sm.mu.RLock()
+		if sm.closed {
+			sm.mu.RUnlock()
+			return ErrClosed
+		}
+
+		if sub != nil && sub.truncated {
+			sm.mu.RUnlock()
+			return ErrTruncated
+		}
+
+		if sm.count > 0 && sm.indexAt(sm.count-1) >= index {
+			sm.mu.RUnlock()
+			return nil
+		}
+
+		appended := sm.appended
+		sm.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-appended:
+		}
+	}
+}
+
+// Subscription delivers entries of a store as they are appended.
+type Subscription[T ~uint64] struct {
+	// C receives copies of the entries in ascending index order.
+	// It is closed when the subscription ends.
+	C <-chan Entry[T]
+
+	mu  *sync.Mutex
+	err error
+}
+
+// Err returns the reason the subscription ended: the error of the context,
+// ErrClosed when the store was closed, ErrTruncated when TruncateAfter removed delivered entries
+// or the error reading an entry.
+// It returns nil while C is still open.
+func (s *Subscription[T]) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Subscribe returns a subscription receiving all entries with an index greater or equal to from,
+// starting with the entries already in the store and continuing with entries appended later.
+// The subscription ends when the context is done or the store is closed.
+// When TruncateAfter removes entries that have already been delivered, for example to rewrite history,
+// the subscription ends with ErrTruncated instead of continuing with the entries appended afterwards.
+func (sm *StateMate[T]) Subscribe(ctx context.Context, from T) *Subscription[T] {
+	c := make(chan Entry[T])
+	s := &Subscription[T]{
+		C:  c,
+		mu: &sync.Mutex{},
+	}
+
+	go func() {
+		sub := sm.addSubscriber()
+		defer sm.removeSubscriber(sub)
+
+		err := sm.deliver(ctx, sub, from, c)
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		close(c)
+	}()
+
+	return s
+}
+
+// subscriber tracks the entries copied by a subscription, it is guarded by the lock of the store.
+type subscriber[T ~uint64] struct {
+	// last is the index of the last copied entry, when copied is true.
+	last      T
+	copied    bool
+	truncated bool
+}
+
+func (sm *StateMate[T]) addSubscriber() *subscriber[T] {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sub := &subscriber[T]{}
+	if sm.subscribers == nil {
+		sm.subscribers = map[*subscriber[T]]struct{}{}
+	}
+	sm.subscribers[sub] = struct{}{}
+
+	return sub
+}
+
+func (sm *StateMate[T]) removeSubscriber(sub *subscriber[T]) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	delete(sm.subscribers, sub)
+}
+
+// truncatedSubscribers ends the subscriptions having copied entries after the first count entries,
+// it is called with the write lock held when entries have been removed from the tail.
+// Waiting subscriptions are woken up to end.
+func (sm *StateMate[T]) truncatedSubscribers(count uint64) {
+	truncated := false
+	for sub := range sm.subscribers {
+		if sub.copied && (count == 0 || sub.last > sm.indexAt(count-1)) {
+			sub.truncated = true
+			truncated = true
+		}
+	}
+
+	if truncated {
+		sm.notifyAppended()
+	}
+}
+
+func (sm *StateMate[T]) deliver(ctx context.Context, sub *subscriber[T], next T, c chan<- Entry[T]) error {
+	for {
+		entries, err := sm.copyEntries(sub, next, subscriptionBatchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) == 0 {
+			err = sm.waitFor(ctx, sub, next)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, e := range entries {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case c <- e:
+			}
+		}
+
+		last := entries[len(entries)-1].Index
+		if uint64(last) == math.MaxUint64 {
+			return nil
+		}
+
+		next = last + 1
+	}
+}
+
+// copyEntries returns copies of up to limit entries with an index greater or equal to from
+// and records the last copied index in the subscriber.
+// Only the goroutine of the subscription modifies the subscriber, so holding the read lock is sufficient.
+func (sm *StateMate[T]) copyEntries(sub *subscriber[T], from T, limit int) ([]Entry[T], error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if sm.closed {
+		return nil, ErrClosed
+	}
+
+	if sub.truncated {
+		return nil, ErrTruncated
+	}
+
+	entries := []Entry[T]{}
+	for pos := sm.search(from); pos < sm.count && len(entries) < limit; pos++ {
+		index := sm.indexAt(pos)
+
+		data, err := sm.entryData(pos)
+		if err != nil {
+			return nil, err
+		}
+
+		data, _, err = sm.decode(index, data, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if sm.codec == nil {
+			data = append([]byte(nil), data...)
+		}
+
+		entries = append(entries, Entry[T]{
+			Index: index,
+			Data:  data,
+		})
+
+		sub.last = index
+		sub.copied = true
+	}
+
+	return entries, nil
+}
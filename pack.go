@@ -0,0 +1,209 @@
+package statemate
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Layout of a packed file:
+//
+//	0  magic           4 bytes "SMPK"
+//	4  version         uint16
+//	6  key width       uint8
+//	7  codec           uint8
+//	8  count           uint64
+//	16 block count     uint64
+//	24 entries offset  uint64
+//	32 blocks offset   uint64
+//	40 reserved        24 bytes
+//	64 compressed blocks
+//
+// followed by the entry records (index uint64, end of data uint64) and the block records
+// (position of the first entry uint64, offset of the compressed block uint64).
+// Data end offsets refer to the uncompressed data of all entries, every block contains whole entries.
+const (
+	packedMagic      = "SMPK"
+	packedHeaderSize = 64
+
+	packedEntrySize = 16
+	packedBlockSize = 16
+
+	defaultBlockSize = 64 * 1024
+)
+
+type PackOptions struct {
+	// Options are used to open the store, only Codec is relevant for stores using a custom codec.
+	Options
+
+	// BlockSize is the uncompressed size of the blocks, blocks are only exceeded by entries larger than it.
+	// Zero means 64KiB.
+	BlockSize int
+	// BlockCodec compresses the blocks, nil means Zstd.
+	// ZstdDictionary is not supported, packed files with custom codecs have to be opened with the same codec.
+	BlockCodec Codec
+}
+
+// Pack writes the entries of the store into a packed file, compressing their data in blocks of entries.
+// Packed files are read-only archives opened with OpenPacked,
+// which decompresses only the block containing an entry to read it.
+// The store is opened read-only, the packed file is written next to packedFileName and renamed when complete.
+// Packed files are not encrypted, so packing an encrypted store returns ErrUnsupportedFormat.
+func Pack(dataFileName, packedFileName string, options PackOptions) (err error) {
+	codec := options.BlockCodec
+	if codec == nil {
+		codec = Zstd
+	}
+
+	if codec.ID() == ZstdDictionary.ID() {
+		return fmt.Errorf("could not pack with ZstdDictionary: %w", ErrUnsupportedFormat)
+	}
+
+	blockSize := options.BlockSize
+	if blockSize == 0 {
+		blockSize = defaultBlockSize
+	}
+
+	sm, err := OpenReadOnly[uint64](dataFileName, options.Options)
+	if err != nil {
+		return fmt.Errorf("could not open store: %w", err)
+	}
+
+	defer func() {
+		err = errors.Join(err, sm.Close())
+	}()
+
+	// the entries would be written to the packed file in plain text
+	if sm.hasFlag(flagEncryption) {
+		return fmt.Errorf("could not pack encrypted store: %w", ErrUnsupportedFormat)
+	}
+
+	newFileName := replacementFileName(packedFileName)
+
+	err = writePacked(sm, newFileName, codec, blockSize)
+	if err != nil {
+		return errors.Join(err, removeIfExists(newFileName))
+	}
+
+	err = os.Rename(newFileName, packedFileName)
+	if err != nil {
+		return fmt.Errorf("could not rename packed file: %w", err)
+	}
+
+	return syncDir(packedFileName)
+}
+
+func writePacked(sm *StateMate[uint64], fileName string, codec Codec, blockSize int) (err error) {
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0700)
+	if err != nil {
+		return fmt.Errorf("could not create packed file: %w", err)
+	}
+
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+
+	w := bufio.NewWriter(f)
+
+	_, err = w.Write(make([]byte, packedHeaderSize))
+	if err != nil {
+		return fmt.Errorf("could not write header: %w", err)
+	}
+
+	offset := uint64(packedHeaderSize)
+	dataEnd := uint64(0)
+	entries := []byte{}
+	blocks := []byte{}
+	block := []byte{}
+	blockFirst := uint64(0)
+	count := uint64(0)
+
+	flush := func() error {
+		compressed, err := codec.Compress(nil, block)
+		if err != nil {
+			return fmt.Errorf("could not compress block: %w", err)
+		}
+
+		_, err = w.Write(compressed)
+		if err != nil {
+			return fmt.Errorf("could not write block: %w", err)
+		}
+
+		blocks = binary.BigEndian.AppendUint64(blocks, blockFirst)
+		blocks = binary.BigEndian.AppendUint64(blocks, offset)
+
+		offset += uint64(len(compressed))
+		block = block[:0]
+		blockFirst = count
+
+		return nil
+	}
+
+	err = sm.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+		if len(block) > 0 && len(block)+len(data) > blockSize {
+			err := flush()
+			if err != nil {
+				return err
+			}
+		}
+
+		block = append(block, data...)
+		dataEnd += uint64(len(data))
+		count++
+
+		entries = binary.BigEndian.AppendUint64(entries, index)
+		entries = binary.BigEndian.AppendUint64(entries, dataEnd)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if count > blockFirst {
+		err = flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(entries)
+	if err != nil {
+		return fmt.Errorf("could not write entries: %w", err)
+	}
+
+	_, err = w.Write(blocks)
+	if err != nil {
+		return fmt.Errorf("could not write blocks: %w", err)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return fmt.Errorf("could not write packed file: %w", err)
+	}
+
+	header := make([]byte, packedHeaderSize)
+	copy(header, packedMagic)
+	binary.BigEndian.PutUint16(header[4:], formatVersion)
+	header[6] = keyWidth
+	header[7] = codec.ID()
+	binary.BigEndian.PutUint64(header[8:], count)
+	binary.BigEndian.PutUint64(header[16:], uint64(len(blocks)/packedBlockSize))
+	binary.BigEndian.PutUint64(header[24:], offset)
+	binary.BigEndian.PutUint64(header[32:], offset+uint64(len(entries)))
+
+	_, err = f.WriteAt(header, 0)
+	if err != nil {
+		return fmt.Errorf("could not write header: %w", err)
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return fmt.Errorf("could not sync packed file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,349 @@
+package statemate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// Packed is a read-only archive of the entries of a store written by Pack.
+// The data of the entries is compressed in blocks, reading an entry decompresses only the block containing it.
+// Packed archives are safe for concurrent use.
+type Packed[T ~uint64] struct {
+	codec Codec
+
+	file *os.File
+	m    mmap.MMap
+
+	count      uint64
+	blockCount uint64
+	entries    []byte
+	blocks     []byte
+
+	mu     *sync.RWMutex
+	closed bool
+}
+
+// OpenPacked opens a packed file written by Pack.
+// Only Options.Codec is relevant, it has to be set when the blocks are compressed with a custom codec.
+func OpenPacked[T ~uint64](packedFileName string, options Options) (*Packed[T], error) {
+	f, err := os.Open(packedFileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %w", err)
+	}
+
+	m, err := mmap.Map(f, mmap.RDONLY, 0)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("could not mmap file: %w", err), f.Close())
+	}
+
+	p, err := newPacked[T](m, options)
+	if err != nil {
+		return nil, errors.Join(err, m.Unmap(), f.Close())
+	}
+
+	p.file = f
+
+	return p, nil
+}
+
+func newPacked[T ~uint64](m mmap.MMap, options Options) (*Packed[T], error) {
+	if len(m) < packedHeaderSize || string(m[:4]) != packedMagic {
+		return nil, fmt.Errorf("packed file: %w", ErrUnsupportedFormat)
+	}
+
+	version := binary.BigEndian.Uint16(m[4:])
+	if version != formatVersion {
+		return nil, fmt.Errorf("packed file version %d: %w", version, ErrUnsupportedFormat)
+	}
+
+	if m[6] != keyWidth {
+		return nil, fmt.Errorf("packed file key width %d: %w", m[6], ErrUnsupportedFormat)
+	}
+
+	codecID := m[7]
+	if codecID == ZstdDictionary.ID() {
+		return nil, fmt.Errorf("codec %d: %w", codecID, ErrUnsupportedFormat)
+	}
+
+	codec, err := resolveCodec(codecID, "", options)
+	if err != nil {
+		return nil, err
+	}
+
+	count := binary.BigEndian.Uint64(m[8:])
+	blockCount := binary.BigEndian.Uint64(m[16:])
+	entriesOffset := binary.BigEndian.Uint64(m[24:])
+	blocksOffset := binary.BigEndian.Uint64(m[32:])
+
+	size := uint64(len(m))
+	if count > size/packedEntrySize || blockCount > count ||
+		entriesOffset < packedHeaderSize || entriesOffset > blocksOffset ||
+		blocksOffset-entriesOffset != count*packedEntrySize ||
+		blocksOffset > size || size-blocksOffset != blockCount*packedBlockSize {
+		return nil, fmt.Errorf("packed file is %d bytes long: %w", size, ErrCorrupted)
+	}
+
+	if (count == 0) != (blockCount == 0) || blockCount > 0 && binary.BigEndian.Uint64(m[blocksOffset:]) != 0 {
+		return nil, fmt.Errorf("packed file has %d entries in %d blocks: %w", count, blockCount, ErrCorrupted)
+	}
+
+	p := &Packed[T]{
+		codec:      codec,
+		m:          m,
+		count:      count,
+		blockCount: blockCount,
+		entries:    m[entriesOffset:blocksOffset],
+		blocks:     m[blocksOffset:],
+		mu:         &sync.RWMutex{},
+	}
+
+	// the block records are checked once, so reads can rely on them
+	for block := uint64(1); block < blockCount; block++ {
+		if p.firstOf(block) <= p.firstOf(block-1) || p.firstOf(block) >= count ||
+			p.offsetOf(block) < p.offsetOf(block-1) || p.offsetOf(block) > entriesOffset {
+			return nil, fmt.Errorf("block %d: %w", block, ErrCorrupted)
+		}
+	}
+
+	return p, nil
+}
+
+// Close unmaps and closes the packed file.
+func (p *Packed[T]) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+
+	p.closed = true
+
+	return errors.Join(p.m.Unmap(), p.file.Close())
+}
+
+func (p *Packed[T]) indexAt(pos uint64) T {
+	return T(binary.BigEndian.Uint64(p.entries[pos*packedEntrySize:]))
+}
+
+func (p *Packed[T]) endOf(pos uint64) uint64 {
+	return binary.BigEndian.Uint64(p.entries[pos*packedEntrySize+8:])
+}
+
+func (p *Packed[T]) startOf(pos uint64) uint64 {
+	if pos == 0 {
+		return 0
+	}
+
+	return p.endOf(pos - 1)
+}
+
+// search returns the position of the first entry with an index greater than or equal to index.
+func (p *Packed[T]) search(index T) uint64 {
+	return uint64(sort.Search(int(p.count), func(i int) bool {
+		return p.indexAt(uint64(i)) >= index
+	}))
+}
+
+// blockOf returns the block containing the entry at the given position.
+func (p *Packed[T]) blockOf(pos uint64) uint64 {
+	return uint64(sort.Search(int(p.blockCount), func(i int) bool {
+		return p.firstOf(uint64(i)) > pos
+	})) - 1
+}
+
+// firstOf returns the position of the first entry of the block.
+func (p *Packed[T]) firstOf(block uint64) uint64 {
+	return binary.BigEndian.Uint64(p.blocks[block*packedBlockSize:])
+}
+
+func (p *Packed[T]) offsetOf(block uint64) uint64 {
+	if block == p.blockCount {
+		return uint64(len(p.m)) - uint64(len(p.blocks)) - uint64(len(p.entries))
+	}
+
+	return binary.BigEndian.Uint64(p.blocks[block*packedBlockSize+8:])
+}
+
+// decompress decompresses the block into buf and returns the uncompressed data of the block.
+func (p *Packed[T]) decompress(block uint64, buf []byte) ([]byte, error) {
+	start, end := p.offsetOf(block), p.offsetOf(block+1)
+	if start > end || end > uint64(len(p.m)) {
+		return nil, fmt.Errorf("block %d: %w", block, ErrCorrupted)
+	}
+
+	data, err := p.codec.Decompress(buf[:0], p.m[start:end])
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress block %d: %w", block, err)
+	}
+
+	first := p.firstOf(block)
+	last := p.count - 1
+	if block+1 < p.blockCount {
+		last = p.firstOf(block+1) - 1
+	}
+
+	if uint64(len(data)) != p.endOf(last)-p.startOf(first) {
+		return nil, fmt.Errorf("block %d: %w", block, ErrCorrupted)
+	}
+
+	return data, nil
+}
+
+// entryData returns the data of the entry at the given position from the uncompressed data of its block.
+func (p *Packed[T]) entryData(pos, block uint64, data []byte) ([]byte, error) {
+	blockStart := p.startOf(p.firstOf(block))
+	start, end := p.startOf(pos), p.endOf(pos)
+
+	if start < blockStart || start > end || end-blockStart > uint64(len(data)) {
+		return nil, fmt.Errorf("entry with index %d: %w", p.indexAt(pos), ErrCorrupted)
+	}
+
+	return data[start-blockStart : end-blockStart], nil
+}
+
+// Read calls fn with the data of the entry with the given index or returns ErrNotFound.
+// The data slice is only valid until fn returns.
+func (p *Packed[T]) Read(index T, fn func(data []byte) error) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+
+	pos := p.search(index)
+	if pos == p.count || p.indexAt(pos) != index {
+		return ErrNotFound
+	}
+
+	block := p.blockOf(pos)
+
+	data, err := p.decompress(block, nil)
+	if err != nil {
+		return err
+	}
+
+	entry, err := p.entryData(pos, block, data)
+	if err != nil {
+		return err
+	}
+
+	return fn(entry)
+}
+
+// Range calls fn for every entry with an index between from and to (both inclusive), in ascending order.
+// Every block is decompressed once while its entries are walked.
+// Iteration stops at the first error returned by fn and that error is returned.
+// The data slice is only valid until fn returns.
+func (p *Packed[T]) Range(from, to T, fn func(index T, data []byte) error) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+
+	var data []byte
+	block := uint64(math.MaxUint64)
+
+	for pos := p.search(from); pos < p.count; pos++ {
+		index := p.indexAt(pos)
+		if index > to {
+			break
+		}
+
+		if block == math.MaxUint64 || block+1 < p.blockCount && p.firstOf(block+1) == pos {
+			var err error
+			block = p.blockOf(pos)
+			data, err = p.decompress(block, data)
+			if err != nil {
+				return err
+			}
+		}
+
+		entry, err := p.entryData(pos, block, data)
+		if err != nil {
+			return err
+		}
+
+		err = fn(index, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var errStopIteration = errors.New("stop iteration")
+
+// All returns an iterator over all entries of the archive.
+// Iteration stops silently when a block cannot be decompressed, use Entries or Range to get the error.
+func (p *Packed[T]) All() iter.Seq2[T, []byte] {
+	return func(yield func(T, []byte) bool) {
+		for e, err := range p.Entries(0, T(uint64(math.MaxUint64))) {
+			if err != nil || !yield(e.Index, e.Data) {
+				return
+			}
+		}
+	}
+}
+
+// Entries returns an iterator over the entries with an index between from and to (both inclusive),
+// in ascending order. The error stopping the iteration, like the error returned by Range, is yielded last
+// with an empty entry. The data of an entry is only valid until the next iteration.
+func (p *Packed[T]) Entries(from, to T) iter.Seq2[Entry[T], error] {
+	return func(yield func(Entry[T], error) bool) {
+		err := p.Range(from, to, func(index T, data []byte) error {
+			if !yield(Entry[T]{Index: index, Data: data}, nil) {
+				return errStopIteration
+			}
+			return nil
+		})
+
+		if err != nil && !errors.Is(err, errStopIteration) {
+			yield(Entry[T]{}, err)
+		}
+	}
+}
+
+func (p *Packed[T]) IsEmpty() bool {
+	return p.count == 0
+}
+
+func (p *Packed[T]) Count() uint64 {
+	return p.count
+}
+
+// GetFirstIndex returns the index of the first entry or math.MaxUint64 when the archive is empty.
+func (p *Packed[T]) GetFirstIndex() T {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.count == 0 || p.closed {
+		return T(uint64(math.MaxUint64))
+	}
+
+	return p.indexAt(0)
+}
+
+// GetLastIndex returns the index of the last entry or math.MaxUint64 when the archive is empty.
+func (p *Packed[T]) GetLastIndex() T {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.count == 0 || p.closed {
+		return T(uint64(math.MaxUint64))
+	}
+
+	return p.indexAt(p.count - 1)
+}
@@ -0,0 +1,121 @@
+package statemate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrLegacyFormat = errors.New("store has to be migrated first")
+
+// PruneBefore removes all entries with an index lower than index and reclaims their disk space.
+// The remaining entries are written into new data and index files which atomically replace the
+// current ones, so the operation needs enough free disk space for a copy of the remaining entries.
+// Legacy stores have to be migrated before they can be pruned.
+func (sm *StateMate[T]) PruneBefore(index T) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+
+	sm.writeMu.Lock()
+	defer sm.writeMu.Unlock()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.closed {
+		return ErrClosed
+	}
+
+	if sm.legacy {
+		return ErrLegacyFormat
+	}
+
+	from := sm.search(index)
+	if from == 0 {
+		return nil
+	}
+
+	if sm.count != sm.persistedCount {
+		err := sm.sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	newFileName := replacementFileName(sm.dataFileName)
+
+	// the new data file is locked before it replaces the current one,
+	// so other writers can not open the store in between
+	newDataFile, err := os.OpenFile(newFileName, os.O_CREATE|os.O_RDWR, 0700)
+	if err != nil {
+		return fmt.Errorf("could not create data file: %w", err)
+	}
+
+	err = lock(newDataFile, true, 0)
+	if err != nil {
+		return errors.Join(err, newDataFile.Close(), removeIfExists(newFileName))
+	}
+
+	err = sm.writeFiles(context.Background(), newFileName, from, sm.count)
+	if err != nil {
+		return errors.Join(
+			fmt.Errorf("could not write pruned files: %w", err),
+			newDataFile.Close(),
+			removeIfExists(newFileName),
+			removeIfExists(newFileName+".idx"),
+		)
+	}
+
+	err = commitReplacement(sm.dataFileName)
+	if err != nil {
+		return errors.Join(err, newDataFile.Close())
+	}
+
+	return sm.reopen(newDataFile)
+}
+
+// reopen replaces the mappings and files of the store with freshly opened ones.
+// Writers pass the already locked new data file, followers pass nil to open it by name.
+func (sm *StateMate[T]) reopen(dataFile *os.File) error {
+	flag := os.O_RDWR
+	if sm.readOnly {
+		flag = os.O_RDONLY
+	}
+
+	if dataFile == nil {
+		var err error
+		dataFile, err = os.OpenFile(sm.dataFileName, flag, 0700)
+		if err != nil {
+			return fmt.Errorf("could not open file: %w", err)
+		}
+	}
+
+	indexFile, err := os.OpenFile(sm.dataFileName+".idx", flag, 0700)
+	if err != nil {
+		return errors.Join(fmt.Errorf("could not open file: %w", err), dataFile.Close())
+	}
+
+	reopened, err := open[T](dataFile, indexFile, sm.options, sm.readOnly)
+	if err != nil {
+		return errors.Join(err, dataFile.Close(), indexFile.Close())
+	}
+
+	err = errors.Join(
+		sm.unmap(sm.data),
+		sm.dataFile.Close(),
+		sm.unmap(sm.index),
+		sm.indexFile.Close(),
+	)
+
+	sm.layout = reopened.layout
+	sm.data = reopened.data
+	sm.dataFile = reopened.dataFile
+	sm.index = reopened.index
+	sm.indexFile = reopened.indexFile
+	sm.count = reopened.count
+	sm.persistedCount = reopened.persistedCount
+
+	return err
+}
@@ -0,0 +1,68 @@
+package statemate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrReadOnly = errors.New("store is read-only")
+
+// OpenReadOnly opens an existing store without ever creating, modifying or resizing its files,
+// so it works on read-only file systems and archived copies of a store.
+// The data file is locked shared, so the store can be opened read-only by many processes,
+// but not by a writer at the same time.
+// A replacement interrupted by a crash is not completed, the replacement index file is read instead.
+// Methods modifying the store return ErrReadOnly.
+func OpenReadOnly[T ~uint64](dataFileName string, options Options) (*StateMate[T], error) {
+	err := checkCipherOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	dataFile, err := openLocked(dataFileName, os.O_RDONLY, options.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	indexFileName, err := readOnlyIndexFileName(dataFileName)
+	if err != nil {
+		return nil, errors.Join(err, dataFile.Close())
+	}
+
+	indexFile, err := os.Open(indexFileName)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("could not open file: %w", err), dataFile.Close())
+	}
+
+	sm, err := open[T](dataFile, indexFile, options, true)
+	if err != nil {
+		return nil, errors.Join(err, dataFile.Close(), indexFile.Close())
+	}
+
+	sm.dataFileName = dataFileName
+
+	return sm, nil
+}
+
+// readOnlyIndexFileName returns the name of the index file belonging to the data file,
+// which is the replacement index file when the replacement was interrupted after renaming the data file.
+func readOnlyIndexFileName(dataFileName string) (string, error) {
+	newFileName := replacementFileName(dataFileName)
+
+	newExists, err := fileExists(newFileName)
+	if err != nil {
+		return "", err
+	}
+
+	newIndexExists, err := fileExists(newFileName + ".idx")
+	if err != nil {
+		return "", err
+	}
+
+	if newIndexExists && !newExists {
+		return newFileName + ".idx", nil
+	}
+
+	return dataFileName + ".idx", nil
+}
@@ -0,0 +1,188 @@
+package statemate
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Files of a store are replaced by writing the new data and index files next to the original ones,
+// data file first, and renaming them over the originals, again data file first.
+// Renaming the data file commits the replacement: when Open finds only the new index file,
+// the replacement is completed, otherwise any leftover new files are removed.
+
+func replacementFileName(dataFileName string) string {
+	return dataFileName + ".new"
+}
+
+// commitReplacement renames the replacement files over the files of the store.
+func commitReplacement(dataFileName string) error {
+	newFileName := replacementFileName(dataFileName)
+
+	err := os.Rename(newFileName, dataFileName)
+	if err != nil {
+		return fmt.Errorf("could not replace data file: %w", err)
+	}
+
+	err = os.Rename(newFileName+".idx", dataFileName+".idx")
+	if err != nil {
+		return fmt.Errorf("could not replace index file: %w", err)
+	}
+
+	return syncDir(dataFileName)
+}
+
+// recoverReplacement completes or discards a replacement that was interrupted by a crash.
+func recoverReplacement(dataFileName string) error {
+	newFileName := replacementFileName(dataFileName)
+
+	newExists, err := fileExists(newFileName)
+	if err != nil {
+		return err
+	}
+
+	newIndexExists, err := fileExists(newFileName + ".idx")
+	if err != nil {
+		return err
+	}
+
+	if newIndexExists && !newExists {
+		err = os.Rename(newFileName+".idx", dataFileName+".idx")
+		if err != nil {
+			return fmt.Errorf("could not complete replacement of index file: %w", err)
+		}
+		return syncDir(dataFileName)
+	}
+
+	err = errors.Join(
+		removeIfExists(newFileName),
+		removeIfExists(newFileName+".idx"),
+	)
+	if err != nil {
+		return fmt.Errorf("could not remove incomplete replacement: %w", err)
+	}
+
+	return nil
+}
+
+// copyChunkSize is the number of data bytes and index records written at once by writeFiles.
+const copyChunkSize = 4 * 1024 * 1024
+
+// writeFiles writes the entries between positions from (inclusive) and to (exclusive)
+// into a new, padding free, data and index file pair.
+// The header of the index file, including the feature flags, is copied from this store.
+// The files are written in chunks and writing stops when the context is done.
+func (sm *StateMate[T]) writeFiles(ctx context.Context, dataFileName string, from, to uint64) (err error) {
+	dataFile, err := os.OpenFile(dataFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0700)
+	if err != nil {
+		return fmt.Errorf("could not create data file: %w", err)
+	}
+
+	defer func() {
+		err = errors.Join(err, dataFile.Close())
+	}()
+
+	indexFile, err := os.OpenFile(dataFileName+".idx", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0700)
+	if err != nil {
+		return fmt.Errorf("could not create index file: %w", err)
+	}
+
+	defer func() {
+		err = errors.Join(err, indexFile.Close())
+	}()
+
+	base := sm.dataStart(from)
+	end := sm.dataStart(to)
+
+	_, err = dataFile.Write(dataFileHeader())
+	if err != nil {
+		return fmt.Errorf("could not write data file header: %w", err)
+	}
+
+	for offset := base; offset < end; offset += copyChunkSize {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		_, err = dataFile.Write(sm.payload()[offset:min(offset+copyChunkSize, end)])
+		if err != nil {
+			return fmt.Errorf("could not write data: %w", err)
+		}
+	}
+
+	header := make([]byte, sm.indexHeaderSize)
+	copy(header, sm.index[:sm.indexHeaderSize])
+	binary.BigEndian.PutUint64(header[sm.countOffset:], to-from)
+
+	_, err = indexFile.Write(header)
+	if err != nil {
+		return fmt.Errorf("could not write index header: %w", err)
+	}
+
+	recordsPerChunk := copyChunkSize / sm.recordSize
+	for pos := from; pos < to; pos += recordsPerChunk {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		chunkEnd := min(pos+recordsPerChunk, to)
+
+		records := make([]byte, sm.indexSize(chunkEnd)-sm.indexSize(pos))
+		copy(records, sm.index[sm.indexSize(pos):sm.indexSize(chunkEnd)])
+		for i := uint64(0); i < chunkEnd-pos; i++ {
+			r := records[i*sm.recordSize:]
+			binary.BigEndian.PutUint64(r[8:], binary.BigEndian.Uint64(r[8:])-base)
+		}
+
+		_, err = indexFile.Write(records)
+		if err != nil {
+			return fmt.Errorf("could not write index: %w", err)
+		}
+	}
+
+	err = dataFile.Sync()
+	if err != nil {
+		return fmt.Errorf("could not sync data file: %w", err)
+	}
+
+	err = indexFile.Sync()
+	if err != nil {
+		return fmt.Errorf("could not sync index file: %w", err)
+	}
+
+	return nil
+}
+
+func fileExists(fileName string) (bool, error) {
+	_, err := os.Stat(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not stat %s: %w", fileName, err)
+	}
+	return true, nil
+}
+
+func removeIfExists(fileName string) error {
+	err := os.Remove(fileName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// syncDir flushes the directory containing fileName, persisting renames of files within it.
+func syncDir(fileName string) error {
+	dir, err := os.Open(filepath.Dir(fileName))
+	if err != nil {
+		return fmt.Errorf("could not open directory: %w", err)
+	}
+
+	return errors.Join(dir.Sync(), dir.Close())
+}
@@ -0,0 +1,103 @@
+package statemate
+
+import (
+	"errors"
+	"time"
+)
+
+// RetentionPolicy selects the old segments of a segmented store that are removed.
+// Every limit that is set has to be satisfied by the remaining segments,
+// so a segment is only removed when none of the limits needs it.
+// Only whole segments are removed and the active segment is always kept.
+type RetentionPolicy[T ~uint64] struct {
+	// KeepEntries is the minimal number of most recent entries to keep.
+	KeepEntries uint64
+	// KeepBytes is the minimal data size of most recent entries to keep.
+	KeepBytes uint64
+	// KeepNewerThan keeps all entries whose Timestamp is within this duration from now.
+	// It requires Timestamp to be set.
+	KeepNewerThan time.Duration
+	// Timestamp returns the time of an entry.
+	Timestamp func(index T, data []byte) time.Time
+
+	// Interval makes retention run in the background at the given interval.
+	// When zero, retention is applied after every Append.
+	Interval time.Duration
+}
+
+func (r RetentionPolicy[T]) enabled() bool {
+	return r.KeepEntries > 0 || r.KeepBytes > 0 || (r.KeepNewerThan > 0 && r.Timestamp != nil)
+}
+
+// ApplyRetention removes the old segments that are not needed by the retention policy.
+func (s *Segmented[T]) ApplyRetention() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	return s.applyRetention()
+}
+
+func (s *Segmented[T]) applyRetention() error {
+	policy := s.options.Retention
+	if !policy.enabled() {
+		return nil
+	}
+
+	entriesAfter := make([]uint64, len(s.segments))
+	bytesAfter := make([]uint64, len(s.segments))
+	for i := len(s.segments) - 2; i >= 0; i-- {
+		next := s.segments[i+1].sm
+		entriesAfter[i] = entriesAfter[i+1] + next.Count()
+		bytesAfter[i] = bytesAfter[i+1] + next.StorageStats().DataSize
+	}
+
+	now := time.Now()
+
+	return s.removeSegments(func(i int) bool {
+		seg := s.segments[i]
+
+		if policy.KeepEntries > 0 && entriesAfter[i] < policy.KeepEntries {
+			return false
+		}
+
+		if policy.KeepBytes > 0 && bytesAfter[i] < policy.KeepBytes {
+			return false
+		}
+
+		if policy.KeepNewerThan > 0 && policy.Timestamp != nil && !seg.sm.IsEmpty() {
+			var ts time.Time
+			lastIndex := seg.sm.GetLastIndex()
+			err := seg.sm.Read(lastIndex, func(data []byte) error {
+				ts = policy.Timestamp(lastIndex, data)
+				return nil
+			})
+			if err != nil || now.Sub(ts) < policy.KeepNewerThan {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+func (s *Segmented[T]) runRetention() {
+	defer close(s.retentionDone)
+
+	ticker := time.NewTicker(s.options.Retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopRetention:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.retentionErr = errors.Join(s.retentionErr, s.applyRetention())
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package statemate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const segmentSuffix = ".seg"
+
+type SegmentedOptions[T ~uint64] struct {
+	// Options are used to open every segment.
+	Options
+
+	// MaxSegmentSize is the data size after which a new segment is started.
+	// Zero means no size limit.
+	MaxSegmentSize uint64
+	// MaxSegmentEntries is the number of entries after which a new segment is started.
+	// Zero means no entry limit.
+	MaxSegmentEntries uint64
+
+	// Retention selects which old segments are removed automatically.
+	Retention RetentionPolicy[T]
+}
+
+// Segmented is a store split into segments, each of them a StateMate with its own data and index file.
+// Entries are appended to the last (active) segment until it reaches the size or entry limit,
+// then the active segment is truncated and a new segment is started.
+// Segments are stored in a directory and named after the index of their first entry,
+// so whole old segments can be archived or removed.
+type Segmented[T ~uint64] struct {
+	dir      string
+	options  SegmentedOptions[T]
+	segments []*segment[T]
+
+	retentionErr  error
+	stopRetention chan struct{}
+	retentionDone chan struct{}
+
+	mu     *sync.RWMutex
+	closed bool
+}
+
+type segment[T ~uint64] struct {
+	firstIndex T
+	fileName   string
+	sm         *StateMate[T]
+}
+
+// SegmentInfo describes a single segment of a segmented store.
+type SegmentInfo[T ~uint64] struct {
+	// FileName is the name of the data file of the segment, the index file has an additional .idx suffix.
+	FileName   string
+	FirstIndex T
+	LastIndex  T
+	Count      uint64
+	DataSize   uint64
+	// Active is true for the segment new entries are appended to.
+	Active bool
+}
+
+func segmentFileName(dir string, firstIndex uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", firstIndex, segmentSuffix))
+}
+
+// OpenSegmented opens or creates a segmented store in the directory dir.
+func OpenSegmented[T ~uint64](dir string, options SegmentedOptions[T]) (*Segmented[T], error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("could not create directory: %w", err)
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory: %w", err)
+	}
+
+	s := &Segmented[T]{
+		dir:     dir,
+		options: options,
+		mu:      &sync.RWMutex{},
+	}
+
+	for _, de := range dirEntries {
+		name := de.Name()
+		if de.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		firstIndex, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		fileName := filepath.Join(dir, name)
+		sm, err := Open[T](fileName, options.Options)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("could not open segment %s: %w", name, err), s.Close())
+		}
+
+		s.segments = append(s.segments, &segment[T]{
+			firstIndex: T(firstIndex),
+			fileName:   fileName,
+			sm:         sm,
+		})
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].firstIndex < s.segments[j].firstIndex
+	})
+
+	if options.Retention.Interval > 0 && options.Retention.enabled() {
+		s.stopRetention = make(chan struct{})
+		s.retentionDone = make(chan struct{})
+		go s.runRetention()
+	}
+
+	return s, nil
+}
+
+// Close stops the background retention and closes all segments.
+// Errors of the background retention are returned by Close.
+// Closing a closed store has no effect, all other methods return ErrClosed or report an empty store.
+func (s *Segmented[T]) Close() error {
+	if s.stopRetention != nil {
+		close(s.stopRetention)
+		<-s.retentionDone
+		s.stopRetention = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	err := s.retentionErr
+	for _, seg := range s.segments {
+		err = errors.Join(err, seg.sm.Close())
+	}
+
+	s.segments = nil
+
+	return err
+}
+
+func (s *Segmented[T]) active() *segment[T] {
+	if len(s.segments) == 0 {
+		return nil
+	}
+	return s.segments[len(s.segments)-1]
+}
+
+func (s *Segmented[T]) isFull(seg *segment[T]) bool {
+	stats := seg.sm.StorageStats()
+	if s.options.MaxSegmentSize > 0 && stats.DataSize >= s.options.MaxSegmentSize {
+		return true
+	}
+
+	if s.options.MaxSegmentEntries > 0 && seg.sm.Count() >= s.options.MaxSegmentEntries {
+		return true
+	}
+
+	return false
+}
+
+// lastIndex returns the index of the last entry in the store.
+func (s *Segmented[T]) lastIndex() (T, bool) {
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		sm := s.segments[i].sm
+		if !sm.IsEmpty() {
+			return sm.GetLastIndex(), true
+		}
+	}
+	return 0, false
+}
+
+// Append appends the entry to the active segment, starting a new segment when the active one is full.
+// Unless the retention policy has an interval, retention is applied after the entry has been appended.
+func (s *Segmented[T]) Append(index T, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	err := s.append(index, data)
+	if err != nil {
+		return err
+	}
+
+	if s.options.Retention.Interval == 0 && s.options.Retention.enabled() {
+		err = s.applyRetention()
+		if err != nil {
+			return fmt.Errorf("could not apply retention: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Segmented[T]) append(index T, data []byte) error {
+	lastIndex, hasLast := s.lastIndex()
+	if hasLast {
+		if lastIndex >= index {
+			return ErrIndexMustBeIncreasing
+		}
+
+		if lastIndex+1 != index && !s.options.AllowGaps {
+			return ErrIndexGapsAreNotAllowed
+		}
+	}
+
+	active := s.active()
+	if active != nil && !s.isFull(active) {
+		return active.sm.Append(index, data)
+	}
+
+	if active != nil {
+		err := active.sm.Truncate()
+		if err != nil {
+			return fmt.Errorf("could not truncate full segment: %w", err)
+		}
+	}
+
+	fileName := segmentFileName(s.dir, uint64(index))
+	sm, err := Open[T](fileName, s.options.Options)
+	if err != nil {
+		return fmt.Errorf("could not create segment: %w", err)
+	}
+
+	err = sm.Append(index, data)
+	if err != nil {
+		return errors.Join(err, sm.Close(), os.Remove(fileName), os.Remove(fileName+".idx"))
+	}
+
+	s.segments = append(s.segments, &segment[T]{
+		firstIndex: index,
+		fileName:   fileName,
+		sm:         sm,
+	})
+
+	return nil
+}
+
+// segmentFor returns the segment that could contain the entry with the given index.
+func (s *Segmented[T]) segmentFor(index T) *segment[T] {
+	i := sort.Search(len(s.segments), func(i int) bool {
+		return s.segments[i].firstIndex > index
+	})
+	if i == 0 {
+		return nil
+	}
+	return s.segments[i-1]
+}
+
+// Read calls fn with the data of the entry with the given index or returns ErrNotFound.
+// The data slice must not be modified and is only valid until fn returns.
+func (s *Segmented[T]) Read(index T, fn func(data []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	seg := s.segmentFor(index)
+	if seg == nil {
+		return ErrNotFound
+	}
+
+	return seg.sm.Read(index, fn)
+}
+
+// Range calls fn for every entry with an index between from and to (both inclusive), in ascending order,
+// walking only the segments overlapping the range.
+// The read lock of the store is held while iterating, so fn must not call Append.
+func (s *Segmented[T]) Range(from, to T, fn func(index T, data []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	for i, seg := range s.segments {
+		if seg.firstIndex > to {
+			break
+		}
+
+		if i+1 < len(s.segments) && s.segments[i+1].firstIndex <= from {
+			continue
+		}
+
+		err := seg.sm.Range(from, to, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Segmented[T]) IsEmpty() bool {
+	return s.Count() == 0
+}
+
+func (s *Segmented[T]) Count() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := uint64(0)
+	for _, seg := range s.segments {
+		count += seg.sm.Count()
+	}
+
+	return count
+}
+
+// GetFirstIndex returns the index of the first entry or math.MaxUint64 when the store is empty.
+func (s *Segmented[T]) GetFirstIndex() T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, seg := range s.segments {
+		if !seg.sm.IsEmpty() {
+			return seg.sm.GetFirstIndex()
+		}
+	}
+
+	return T(uint64(math.MaxUint64))
+}
+
+// GetLastIndex returns the index of the last entry or math.MaxUint64 when the store is empty.
+func (s *Segmented[T]) GetLastIndex() T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lastIndex, hasLast := s.lastIndex()
+	if !hasLast {
+		return T(uint64(math.MaxUint64))
+	}
+
+	return lastIndex
+}
+
+// Sync flushes the active segment.
+func (s *Segmented[T]) Sync() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	active := s.active()
+	if active == nil {
+		return nil
+	}
+
+	return active.sm.Sync()
+}
+
+// Segments returns the description of all segments, ordered by their first index.
+// All but the active segment are truncated and not modified anymore,
+// so their files can be safely copied for archiving.
+func (s *Segmented[T]) Segments() []SegmentInfo[T] {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]SegmentInfo[T], len(s.segments))
+	for i, seg := range s.segments {
+		infos[i] = SegmentInfo[T]{
+			FileName:   seg.fileName,
+			FirstIndex: seg.firstIndex,
+			LastIndex:  seg.sm.GetLastIndex(),
+			Count:      seg.sm.Count(),
+			DataSize:   seg.sm.StorageStats().DataSize,
+			Active:     i == len(s.segments)-1,
+		}
+	}
+
+	return infos
+}
+
+// RemoveSegmentsBefore closes and deletes all segments containing only entries with an index lower than index.
+// The active segment is never removed.
+func (s *Segmented[T]) RemoveSegmentsBefore(index T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	return s.removeSegments(func(i int) bool {
+		return s.segments[i+1].firstIndex <= index
+	})
+}
+
+// removeSegments removes leading segments for whose position remove returns true,
+// stopping at the first segment that is kept or the active segment.
+func (s *Segmented[T]) removeSegments(remove func(i int) bool) error {
+	removed := 0
+	for ; removed < len(s.segments)-1; removed++ {
+		if !remove(removed) {
+			break
+		}
+
+		seg := s.segments[removed]
+
+		err := errors.Join(
+			seg.sm.Close(),
+			os.Remove(seg.fileName),
+			os.Remove(seg.fileName+".idx"),
+			removeIfExists(dictionaryFileName(seg.fileName)),
+		)
+		if err != nil {
+			s.segments = s.segments[removed+1:]
+			return fmt.Errorf("could not remove segment %s: %w", seg.fileName, err)
+		}
+	}
+
+	s.segments = s.segments[removed:]
+
+	return nil
+}
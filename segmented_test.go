@@ -0,0 +1,299 @@
+package statemate_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/draganm/statemate"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Segmented", func() {
+
+	var dir string
+	var s *statemate.Segmented[uint64]
+	var options statemate.SegmentedOptions[uint64]
+
+	BeforeEach(func() {
+		tempDir, err := os.MkdirTemp("", "")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(func() {
+			err := os.RemoveAll(tempDir)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		dir = filepath.Join(tempDir, "segments")
+		options = statemate.SegmentedOptions[uint64]{MaxSegmentEntries: 3}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		s, err = statemate.OpenSegmented[uint64](dir, options)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(func() {
+			err := s.Close()
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	readAll := func() map[uint64][]byte {
+		m := map[uint64][]byte{}
+		err := s.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+			d := make([]byte, len(data))
+			copy(d, data)
+			m[index] = d
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return m
+	}
+
+	Context("when the store is empty", func() {
+		It("should not have any segments", func() {
+			Expect(s.Segments()).To(BeEmpty())
+			Expect(s.IsEmpty()).To(BeTrue())
+			Expect(s.GetFirstIndex()).To(Equal(uint64(math.MaxUint64)))
+			Expect(s.GetLastIndex()).To(Equal(uint64(math.MaxUint64)))
+		})
+
+		It("should return ErrNotFound on Read", func() {
+			err := s.Read(1, func(data []byte) error { return nil })
+			Expect(err).To(Equal(statemate.ErrNotFound))
+		})
+	})
+
+	Context("when entries exceeding the segment entry limit are appended", func() {
+		JustBeforeEach(func() {
+			for i := uint64(1); i <= 7; i++ {
+				Expect(s.Append(i, []byte{byte(i)})).To(Succeed())
+			}
+		})
+
+		It("should roll over to new segments", func() {
+			segments := s.Segments()
+			Expect(segments).To(HaveLen(3))
+			Expect(segments[0].FirstIndex).To(Equal(uint64(1)))
+			Expect(segments[0].LastIndex).To(Equal(uint64(3)))
+			Expect(segments[0].Active).To(BeFalse())
+			Expect(segments[1].FirstIndex).To(Equal(uint64(4)))
+			Expect(segments[2].FirstIndex).To(Equal(uint64(7)))
+			Expect(segments[2].Count).To(Equal(uint64(1)))
+			Expect(segments[2].Active).To(BeTrue())
+		})
+
+		It("should name the segment files after their first index", func() {
+			Expect(s.Segments()[1].FileName).To(Equal(filepath.Join(dir, "00000000000000000004.seg")))
+		})
+
+		It("should read entries from all segments", func() {
+			for i := uint64(1); i <= 7; i++ {
+				var d []byte
+				err := s.Read(i, func(data []byte) error {
+					d = append(d, data...)
+					return nil
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(d).To(Equal([]byte{byte(i)}))
+			}
+		})
+
+		It("should range over segment boundaries", func() {
+			indices := []uint64{}
+			err := s.Range(3, 5, func(index uint64, data []byte) error {
+				indices = append(indices, index)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(indices).To(Equal([]uint64{3, 4, 5}))
+		})
+
+		It("should report first and last index and count", func() {
+			Expect(s.GetFirstIndex()).To(Equal(uint64(1)))
+			Expect(s.GetLastIndex()).To(Equal(uint64(7)))
+			Expect(s.Count()).To(Equal(uint64(7)))
+		})
+
+		It("should reject indices that are not increasing", func() {
+			Expect(s.Append(7, []byte{7})).To(Equal(statemate.ErrIndexMustBeIncreasing))
+		})
+
+		It("should reject gaps", func() {
+			Expect(s.Append(9, []byte{9})).To(Equal(statemate.ErrIndexGapsAreNotAllowed))
+		})
+
+		It("should reopen all segments", func() {
+			Expect(s.Close()).To(Succeed())
+			var err error
+			s, err = statemate.OpenSegmented[uint64](dir, options)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Segments()).To(HaveLen(3))
+			Expect(readAll()).To(HaveLen(7))
+			Expect(s.Append(8, []byte{8})).To(Succeed())
+			Expect(s.Segments()).To(HaveLen(3))
+		})
+
+		It("should return ErrClosed after Close without creating segments", func() {
+			Expect(s.Close()).To(Succeed())
+			Expect(s.Close()).To(Succeed())
+
+			Expect(s.Append(8, []byte{8})).To(MatchError(statemate.ErrClosed))
+			Expect(s.Read(1, func([]byte) error { return nil })).To(MatchError(statemate.ErrClosed))
+			Expect(s.Range(0, math.MaxUint64, func(uint64, []byte) error { return nil })).To(MatchError(statemate.ErrClosed))
+			Expect(s.Sync()).To(MatchError(statemate.ErrClosed))
+			Expect(s.ApplyRetention()).To(MatchError(statemate.ErrClosed))
+			Expect(s.RemoveSegmentsBefore(5)).To(MatchError(statemate.ErrClosed))
+
+			entries, err := os.ReadDir(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(6))
+		})
+
+		When("I remove segments before an index", func() {
+			JustBeforeEach(func() {
+				Expect(s.RemoveSegmentsBefore(5)).To(Succeed())
+			})
+
+			It("should remove only segments with all entries before the index", func() {
+				segments := s.Segments()
+				Expect(segments).To(HaveLen(2))
+				Expect(segments[0].FirstIndex).To(Equal(uint64(4)))
+				Expect(s.GetFirstIndex()).To(Equal(uint64(4)))
+			})
+
+			It("should delete the segment files", func() {
+				_, err := os.Stat(filepath.Join(dir, "00000000000000000001.seg"))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+				_, err = os.Stat(filepath.Join(dir, "00000000000000000001.seg.idx"))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+
+		When("I remove segments after the last index", func() {
+			It("should keep the active segment", func() {
+				Expect(s.RemoveSegmentsBefore(100)).To(Succeed())
+				Expect(s.Segments()).To(HaveLen(1))
+				Expect(s.GetLastIndex()).To(Equal(uint64(7)))
+			})
+		})
+	})
+
+	Context("when the segment size limit is set", func() {
+		BeforeEach(func() {
+			options = statemate.SegmentedOptions[uint64]{MaxSegmentSize: 10}
+		})
+
+		It("should roll over when the data size reaches the limit", func() {
+			Expect(s.Append(1, make([]byte, 6))).To(Succeed())
+			Expect(s.Append(2, make([]byte, 6))).To(Succeed())
+			Expect(s.Append(3, make([]byte, 6))).To(Succeed())
+			segments := s.Segments()
+			Expect(segments).To(HaveLen(2))
+			Expect(segments[0].DataSize).To(Equal(uint64(12)))
+		})
+	})
+
+	Context("when a retention policy is set", func() {
+		appendEntries := func(n uint64) {
+			for i := uint64(1); i <= n; i++ {
+				Expect(s.Append(i, []byte{byte(i)})).To(Succeed())
+			}
+		}
+
+		firstIndices := func() []uint64 {
+			indices := []uint64{}
+			for _, segment := range s.Segments() {
+				indices = append(indices, segment.FirstIndex)
+			}
+			return indices
+		}
+
+		When("keeping the last entries", func() {
+			BeforeEach(func() {
+				options.Retention = statemate.RetentionPolicy[uint64]{KeepEntries: 4}
+			})
+
+			It("should remove segments not needed to keep the entries", func() {
+				appendEntries(10)
+				Expect(firstIndices()).To(Equal([]uint64{7, 10}))
+				Expect(s.Count()).To(Equal(uint64(4)))
+			})
+		})
+
+		When("keeping the last bytes", func() {
+			BeforeEach(func() {
+				options.Retention = statemate.RetentionPolicy[uint64]{KeepBytes: 3}
+			})
+
+			It("should remove segments not needed to keep the bytes", func() {
+				appendEntries(10)
+				Expect(firstIndices()).To(Equal([]uint64{7, 10}))
+			})
+		})
+
+		When("keeping entries newer than a duration", func() {
+			BeforeEach(func() {
+				options.Retention = statemate.RetentionPolicy[uint64]{
+					KeepNewerThan: time.Hour,
+					Timestamp: func(index uint64, data []byte) time.Time {
+						if index <= 5 {
+							return time.Now().Add(-2 * time.Hour)
+						}
+						return time.Now()
+					},
+				}
+			})
+
+			It("should remove segments with only old entries", func() {
+				appendEntries(10)
+				Expect(firstIndices()).To(Equal([]uint64{4, 7, 10}))
+			})
+		})
+
+		When("combining limits", func() {
+			BeforeEach(func() {
+				options.Retention = statemate.RetentionPolicy[uint64]{KeepEntries: 1, KeepBytes: 5}
+			})
+
+			It("should keep what is needed by every limit", func() {
+				appendEntries(10)
+				Expect(firstIndices()).To(Equal([]uint64{4, 7, 10}))
+			})
+		})
+
+		When("retention runs in the background", func() {
+			BeforeEach(func() {
+				options.Retention = statemate.RetentionPolicy[uint64]{
+					KeepEntries: 1,
+					Interval:    10 * time.Millisecond,
+				}
+			})
+
+			It("should not remove segments on Append", func() {
+				appendEntries(10)
+				Expect(firstIndices()).To(HaveLen(4))
+			})
+
+			It("should eventually remove old segments", func() {
+				appendEntries(10)
+				Eventually(firstIndices).Should(Equal([]uint64{10}))
+			})
+		})
+
+		When("retention is applied explicitly", func() {
+			BeforeEach(func() {
+				options.Retention = statemate.RetentionPolicy[uint64]{
+					KeepEntries: 1,
+					Interval:    time.Hour,
+				}
+			})
+
+			It("should remove old segments", func() {
+				appendEntries(10)
+				Expect(s.ApplyRetention()).To(Succeed())
+				Expect(firstIndices()).To(Equal([]uint64{10}))
+			})
+		})
+	})
+})
@@ -0,0 +1,145 @@
+package statemate
+
+import (
+	"errors"
+	"iter"
+	"sync"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+var ErrSnapshotsOpen = errors.New("snapshots are open")
+
+// Snapshot is a stable view of the entries of a store at the time it was taken.
+// Reading a snapshot does not hold the lock of the store, so long-running readers
+// do not block appends and do not see entries appended after the snapshot was taken.
+// Unlike with the store, the loop body of iterators over a snapshot may append to the store.
+// The mappings of the store are pinned until the snapshot is released,
+// so snapshots should not be kept open longer than necessary.
+type Snapshot[T ~uint64] struct {
+	sm   *StateMate[T]
+	view *StateMate[T]
+
+	releaseOnce *sync.Once
+}
+
+// Snapshot pins the current entries of the store until the snapshot is released.
+// While snapshots are open, TruncateAfter returns ErrSnapshotsOpen.
+func (sm *StateMate[T]) Snapshot() (*Snapshot[T], error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.closed {
+		return nil, ErrClosed
+	}
+
+	sm.snapshots++
+
+	return &Snapshot[T]{
+		sm: sm,
+		view: &StateMate[T]{
+			options:        sm.options,
+			layout:         sm.layout,
+			dataFileName:   sm.dataFileName,
+			readOnly:       true,
+			codec:          sm.codec,
+			keys:           sm.keys,
+			data:           sm.data,
+			index:          sm.index,
+			count:          sm.count,
+			persistedCount: sm.count,
+			appended:       make(chan struct{}),
+			writeMu:        &sync.Mutex{},
+			mu:             &sync.RWMutex{},
+		},
+		releaseOnce: &sync.Once{},
+	}, nil
+}
+
+// Release unpins the mappings of the store, the snapshot must not be used afterwards.
+// Releasing a snapshot more than once has no effect.
+func (s *Snapshot[T]) Release() error {
+	var err error
+	s.releaseOnce.Do(func() {
+		s.sm.mu.Lock()
+		defer s.sm.mu.Unlock()
+
+		s.sm.snapshots--
+		if s.sm.snapshots == 0 {
+			err = s.sm.unmapRetired()
+		}
+	})
+	return err
+}
+
+// Read calls fn with the data of the entry with the given index or returns ErrNotFound, see StateMate.Read.
+func (s *Snapshot[T]) Read(index T, fn func(data []byte) error) error {
+	return s.view.Read(index, fn)
+}
+
+// Range calls fn for every entry with an index between from and to (both inclusive), see StateMate.Range.
+func (s *Snapshot[T]) Range(from, to T, fn func(index T, data []byte) error) error {
+	return s.view.Range(from, to, fn)
+}
+
+// All returns an iterator over all entries of the snapshot, see StateMate.All.
+func (s *Snapshot[T]) All() iter.Seq2[T, []byte] {
+	return s.view.All()
+}
+
+// Between returns an iterator over the entries with an index between from and to (both inclusive), see StateMate.Between.
+func (s *Snapshot[T]) Between(from, to T) iter.Seq2[T, []byte] {
+	return s.view.Between(from, to)
+}
+
+// Entries returns an iterator over the entries with an index between from and to (both inclusive)
+// yielding the errors reading them, see StateMate.Entries.
+func (s *Snapshot[T]) Entries(from, to T) iter.Seq2[Entry[T], error] {
+	return s.view.Entries(from, to)
+}
+
+// Backward returns an iterator over all entries of the snapshot in descending index order, see StateMate.Backward.
+func (s *Snapshot[T]) Backward() iter.Seq2[T, []byte] {
+	return s.view.Backward()
+}
+
+func (s *Snapshot[T]) IsEmpty() bool {
+	return s.view.IsEmpty()
+}
+
+func (s *Snapshot[T]) Count() uint64 {
+	return s.view.Count()
+}
+
+// GetFirstIndex returns the index of the first entry or math.MaxUint64 when the snapshot is empty.
+func (s *Snapshot[T]) GetFirstIndex() T {
+	return s.view.GetFirstIndex()
+}
+
+// GetLastIndex returns the index of the last entry or math.MaxUint64 when the snapshot is empty.
+func (s *Snapshot[T]) GetLastIndex() T {
+	return s.view.GetLastIndex()
+}
+
+// unmap unmaps a mapping that is no longer used by the store,
+// unless it is pinned by open snapshots.
+func (sm *StateMate[T]) unmap(m mmap.MMap) error {
+	if sm.snapshots > 0 {
+		sm.retired = append(sm.retired, m)
+		return nil
+	}
+
+	return m.Unmap()
+}
+
+// unmapRetired unmaps the mappings retired while snapshots were open.
+func (sm *StateMate[T]) unmapRetired() error {
+	var err error
+	for _, m := range sm.retired {
+		err = errors.Join(err, m.Unmap())
+	}
+
+	sm.retired = nil
+
+	return err
+}
@@ -1,31 +1,123 @@
 package statemate
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 	"os"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/edsrzf/mmap-go"
 )
 
 type StateMate[T ~uint64] struct {
 	options Options
-
-	readOnlyData  mmap.MMap
-	data          *os.File
-	readOnlyIndex mmap.MMap
-	index         *os.File
-
-	mu *sync.RWMutex
+	layout
+
+	dataFileName string
+
+	// readOnly stores never modify, create or resize their files.
+	readOnly bool
+
+	// codec compresses the data of the entries, it is nil for stores without compression.
+	codec Codec
+	// dictionaryRetryAt is the number of entries at which automatic training of the dictionary is retried,
+	// it is guarded by writeMu. dictionaryErr is the error of the last automatic training.
+	dictionaryRetryAt uint64
+	dictionaryErr     error
+
+	// keys encrypt the data of the entries of stores with flagEncryption, it is nil without Options.Cipher.
+	keys *keyring
+	// keyID identifies the key encrypting appended entries and lastEncrypted is the last index encrypted with it.
+	// Both are guarded by writeMu.
+	keyID         []byte
+	lastEncrypted T
+
+	// data and index are long-lived writable mappings of the data and index files.
+	// They are only recreated when the size of the underlying file changes.
+	data      mmap.MMap
+	dataFile  *os.File
+	index     mmap.MMap
+	indexFile *os.File
+
+	// count is the number of entries visible to readers.
+	// persistedCount is the number of entries stored in the index header,
+	// which lags behind count until the next sync in deferred sync modes.
+	count          uint64
+	persistedCount uint64
+
+	syncTimer *time.Timer
+	syncErr   error
+	closed    bool
+
+	// appended is closed and replaced whenever new entries become visible.
+	appended chan struct{}
+	// stopFollowing stops polling the files of a follower.
+	stopFollowing chan struct{}
+	// truncations is the number of truncations in the index header at the last poll of a follower.
+	truncations uint64
+	// subscribers are the active subscriptions, which end when their entries are removed by TruncateAfter.
+	subscribers map[*subscriber[T]]struct{}
+
+	// snapshots is the number of open snapshots.
+	// While snapshots are open, replaced mappings are retired instead of unmapped.
+	snapshots int
+	retired   []mmap.MMap
+
+	// writeMu serializes writers, including open transactions, and is always acquired before mu.
+	writeMu *sync.Mutex
+	mu      *sync.RWMutex
 }
 
 type Options struct {
 	AllowGaps bool
-	MaxSize   uint64
+	// MaxSize limits the size of the stored data, not including the data file header.
+	MaxSize uint64
+
+	// Sync selects when appended entries are flushed to disk, see SyncMode.
+	Sync SyncMode
+	// SyncEvery is the number of appended entries after which the files are flushed in SyncEveryN mode.
+	SyncEvery uint64
+	// SyncInterval is the maximal time appended entries stay unflushed in SyncInterval mode.
+	SyncInterval time.Duration
+
+	// Checksums stores a CRC32C checksum of every entry in the index,
+	// which is verified when the entry is read.
+	// It is recorded in the header when a new store is created and ignored for existing stores.
+	Checksums bool
+
+	// Codec compresses the data of every entry, see Codec.
+	// Like Checksums, it is recorded in the header when a new store is created and ignored for existing stores.
+	// Stores compressed with a custom codec have to be opened with the same codec.
+	// Legacy stores do not support compression.
+	Codec Codec
+
+	// DictionaryEntries is the number of entries after which the dictionary of a store
+	// compressed with ZstdDictionary is trained from these entries.
+	// When the entries are not suitable for training, appending continues without the dictionary
+	// and training is retried with twice as many entries, see StateMate.DictionaryError.
+	// Zero means the dictionary is only trained by calling TrainDictionary.
+	DictionaryEntries uint64
+
+	// Cipher encrypts the data of every entry, see NewAESGCM.
+	// It is recorded in the header when a new store is created, encrypted stores have to be opened with a cipher
+	// using the same key and opening a store that is not encrypted with a cipher returns ErrNotEncrypted.
+	// Entries are compressed before they are encrypted, checksums are calculated over the encrypted data.
+	Cipher Cipher
+
+	// Repair makes Open roll back a corrupted index to its last consistent entry
+	// instead of returning a CorruptedError.
+	Repair bool
+
+	// LockTimeout is how long Open waits for the lock of a store opened by another process.
+	// Zero makes Open return ErrLocked immediately.
+	LockTimeout time.Duration
+
+	// ReadOnly opens an existing store without ever creating, modifying or resizing its files,
+	// see OpenReadOnly.
+	ReadOnly bool
 }
 
 func (o Options) GetMaxSize() uint64 {
@@ -36,43 +128,104 @@ func (o Options) GetMaxSize() uint64 {
 	return o.MaxSize
 }
 
+// Open opens or creates the store with the given data file name, the index file has an additional .idx suffix.
+// The data file is locked exclusively, so a store can only be opened by one writer at a time,
+// see Options.LockTimeout.
 func Open[T ~uint64](dataFileName string, options Options) (*StateMate[T], error) {
-	dataFile, err := os.OpenFile(dataFileName, os.O_CREATE|os.O_RDWR, 0700)
+	if options.ReadOnly {
+		return OpenReadOnly[T](dataFileName, options)
+	}
+
+	err := checkCipherOptions(options)
 	if err != nil {
-		return nil, fmt.Errorf("could not open file: %w", err)
+		return nil, err
 	}
+
+	indexFileName := dataFileName + ".idx"
+
+	dataFile, err := openLocked(dataFileName, os.O_CREATE|os.O_RDWR, options.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recoverReplacement(dataFileName)
+	if err != nil {
+		return nil, errors.Join(err, dataFile.Close())
+	}
+
 	{
 		fi, err := dataFile.Stat()
 		if err != nil {
-			return nil, fmt.Errorf("could not stat file: %w", err)
+			return nil, errors.Join(fmt.Errorf("could not stat file: %w", err), dataFile.Close())
 		}
 
-		if fi.Size() < 1 {
-			err = dataFile.Truncate(1)
-			if err != nil {
-				return nil, fmt.Errorf("failed extending index file to 8 bytes: %w", err)
+		if fi.Size() > 0 {
+			_, err = os.Stat(indexFileName)
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, errors.Join(
+					fmt.Errorf("data file %s exists without index file: %w", dataFileName, ErrInvalidFormat),
+					dataFile.Close(),
+				)
 			}
 		}
+	}
 
-		if uint64(fi.Size()) > options.GetMaxSize() {
-			return nil, fmt.Errorf("file size %d is larger than max size %d", fi.Size(), options.MaxSize)
-		}
+	indexFile, err := os.OpenFile(indexFileName, os.O_CREATE|os.O_RDWR, 0700)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("could not open file: %w", err), dataFile.Close())
 	}
 
-	indexFileName := dataFileName + ".idx"
+	sm, err := open[T](dataFile, indexFile, options, false)
+	if err != nil {
+		return nil, errors.Join(err, dataFile.Close(), indexFile.Close())
+	}
 
-	indexFile, err := os.OpenFile(indexFileName, os.O_CREATE|os.O_RDWR, 0700)
+	sm.dataFileName = dataFileName
+
+	return sm, nil
+
+}
+
+// open maps the opened data and index files, initializing them when both are empty.
+// Files of readOnly stores are never initialized or resized and are mapped read-only.
+func open[T ~uint64](dataFile, indexFile *os.File, options Options, readOnly bool) (*StateMate[T], error) {
+	dataInfo, err := dataFile.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("could not open file: %w", err)
+		return nil, fmt.Errorf("could not stat file: %w", err)
 	}
-	{
 
-		fi, err := indexFile.Stat()
+	indexInfo, err := indexFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat file: %w", err)
+	}
+
+	var l layout
+	if dataInfo.Size() == 0 && indexInfo.Size() == 0 && !readOnly {
+		l = optionsLayout(options)
+		err = initFiles(dataFile, indexFile, l)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		l, err = readLayout(dataFile, indexFile)
 		if err != nil {
-			return nil, fmt.Errorf("could not stat file: %w", err)
+			return nil, err
 		}
+	}
+
+	if l.legacy && readOnly && dataInfo.Size() < 1 {
+		return nil, fmt.Errorf("data file is empty: %w", ErrInvalidFormat)
+	}
 
-		if fi.Size() < 16 {
+	if l.legacy && !readOnly {
+		if dataInfo.Size() < 1 {
+			err = dataFile.Truncate(1)
+			if err != nil {
+				return nil, fmt.Errorf("failed extending data file to 1 byte: %w", err)
+			}
+		}
+
+		if indexInfo.Size() < 16 {
 			err = indexFile.Truncate(8)
 			if err != nil {
 				return nil, fmt.Errorf("failed extending index file to 8 bytes: %w", err)
@@ -80,34 +233,97 @@ func Open[T ~uint64](dataFileName string, options Options) (*StateMate[T], error
 		}
 	}
 
-	readOnlyData, err := mmap.Map(dataFile, mmap.RDONLY, 0)
+	err = checkCipher(l, options)
 	if err != nil {
-		return nil, fmt.Errorf("could not create read only data mmap: %w", err)
+		return nil, err
 	}
 
-	readOnlyIndex, err := mmap.Map(indexFile, mmap.RDONLY, 0)
+	var codec Codec
+	if l.hasFlag(flagCompression) {
+		codec, err = resolveCodec(l.codecID, dataFile.Name(), options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dataInfo.Size() > int64(l.dataHeaderSize) && uint64(dataInfo.Size())-l.dataHeaderSize > options.GetMaxSize() {
+		return nil, fmt.Errorf("file size %d is larger than max size %d", dataInfo.Size(), options.MaxSize)
+	}
+
+	mode := mmap.RDWR
+	if readOnly {
+		mode = mmap.RDONLY
+	}
+
+	data, err := mmap.Map(dataFile, mode, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not create data mmap: %w", err)
+	}
+
+	index, err := mmap.Map(indexFile, mode, 0)
 	if err != nil {
-		return nil, fmt.Errorf("could not create read only index mmap: %w", err)
+		return nil, errors.Join(fmt.Errorf("could not create index mmap: %w", err), data.Unmap())
 	}
 
-	return &StateMate[T]{
-		options:       options,
-		readOnlyData:  readOnlyData,
-		data:          dataFile,
-		readOnlyIndex: readOnlyIndex,
-		index:         indexFile,
-		mu:            &sync.RWMutex{},
-	}, nil
+	sm := &StateMate[T]{
+		options:   options,
+		layout:    l,
+		readOnly:  readOnly,
+		codec:     codec,
+		keys:      newKeyring(options.Cipher),
+		data:      data,
+		dataFile:  dataFile,
+		index:     index,
+		indexFile: indexFile,
+		appended:  make(chan struct{}),
+		writeMu:   &sync.Mutex{},
+		mu:        &sync.RWMutex{},
+	}
 
+	corrupted := sm.checkIndex()
+	if corrupted != nil {
+		if !options.Repair || readOnly {
+			return nil, errors.Join(corrupted, data.Unmap(), index.Unmap())
+		}
+
+		err = sm.repairIndex(corrupted)
+		if err != nil {
+			return nil, errors.Join(err, data.Unmap(), index.Unmap())
+		}
+	}
+
+	sm.count = sm.headerCount()
+	sm.persistedCount = sm.count
+
+	return sm, nil
 }
 
 func (sm *StateMate[T]) Close() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.closed {
+		return ErrClosed
+	}
+
+	var syncErr error
+	if sm.count != sm.persistedCount {
+		syncErr = sm.sync()
+	}
+
+	sm.closed = true
+	close(sm.appended)
+	if sm.stopFollowing != nil {
+		close(sm.stopFollowing)
+	}
 
 	return errors.Join(
-		sm.readOnlyData.Unmap(),
-		sm.data.Close(),
-		sm.readOnlyIndex.Unmap(),
-		sm.index.Close(),
+		sm.takeSyncError(),
+		syncErr,
+		sm.unmap(sm.data),
+		sm.dataFile.Close(),
+		sm.unmap(sm.index),
+		sm.indexFile.Close(),
 	)
 
 }
@@ -137,106 +353,175 @@ func calculateNewSize(currentSize uint64, spaceAvailable uint64, spaceNeeded uin
 	return newSize, nil
 }
 
+// resizeData changes the size of the data file and recreates the data mapping.
+func (sm *StateMate[T]) resizeData(newSize uint64) error {
+	err := sm.dataFile.Truncate(int64(newSize))
+	if err != nil {
+		return fmt.Errorf("could not truncate data file to new size %d: %w", newSize, err)
+	}
+	err = sm.unmap(sm.data)
+	if err != nil {
+		return fmt.Errorf("could not unmap data mmap: %w", err)
+	}
+	data, err := mmap.Map(sm.dataFile, mmap.RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("could not create resized data mmap: %w", err)
+	}
+
+	sm.data = data
+
+	return nil
+}
+
+// resizeIndex changes the size of the index file and recreates the index mapping.
+func (sm *StateMate[T]) resizeIndex(newSize uint64) error {
+	err := sm.indexFile.Truncate(int64(newSize))
+	if err != nil {
+		return fmt.Errorf("could not truncate index file to new size %d: %w", newSize, err)
+	}
+	err = sm.unmap(sm.index)
+	if err != nil {
+		return fmt.Errorf("could not unmap index mmap: %w", err)
+	}
+	index, err := mmap.Map(sm.indexFile, mmap.RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("could not create resized index mmap: %w", err)
+	}
+
+	sm.index = index
+
+	return nil
+}
+
 var ErrIndexMustBeIncreasing = errors.New("index must be increasing")
 var ErrIndexGapsAreNotAllowed = errors.New("index gaps are not allowed")
 
+// Entry is a single index and data pair.
+type Entry[T ~uint64] struct {
+	Index T
+	Data  []byte
+}
+
 func (sm *StateMate[T]) Append(index T, data []byte) error {
+	return sm.AppendBatch([]Entry[T]{{Index: index, Data: data}})
+}
+
+// AppendBatch appends all entries in a single operation.
+// Ordering and gap rules are validated for the whole batch before anything is written,
+// so either all entries are appended or none is.
+// Data and index files are grown at most once and the entry count is updated once at the end.
+func (sm *StateMate[T]) AppendBatch(entries []Entry[T]) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	entries, err := sm.encode(entries)
+	if err != nil {
+		return err
+	}
+
+	sm.writeMu.Lock()
+	defer sm.writeMu.Unlock()
+
+	sm.trainDictionaryIfDue()
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	count := binary.BigEndian.Uint64(sm.readOnlyIndex[:8])
+	return sm.appendBatch(entries)
+}
 
-	endOfLastData := uint64(0)
-	if count > 0 {
-		endOfLastData = binary.BigEndian.Uint64(sm.readOnlyIndex[8:][(count-1)*16+8:])
-		lastIndex := binary.BigEndian.Uint64(sm.readOnlyIndex[8:][(count-1)*16:])
-		if T(lastIndex) >= index {
-			return ErrIndexMustBeIncreasing
-		}
+// lastIndex returns the index of the last entry or math.MaxUint64 when the store is empty or closed.
+func (sm *StateMate[T]) lastIndex() T {
+	if sm.count == 0 || sm.closed {
+		return T(uint64(math.MaxUint64))
+	}
 
-		if T(lastIndex)+1 != index && !sm.options.AllowGaps {
-			return ErrIndexGapsAreNotAllowed
-		}
+	return sm.indexAt(sm.count - 1)
+}
 
+func (sm *StateMate[T]) appendBatch(entries []Entry[T]) error {
+	if sm.closed {
+		return ErrClosed
 	}
 
-	available := len(sm.readOnlyData) - int(endOfLastData)
+	err := sm.stage(sm.count, entries)
+	if err != nil {
+		return err
+	}
 
-	if available <= len(data) {
-		newSize, err := calculateNewSize(endOfLastData, uint64(available), uint64(len(data)), sm.options.GetMaxSize())
-		if err != nil {
-			return err
-		}
+	return sm.commit(sm.count + uint64(len(entries)))
+}
 
-		err = sm.data.Truncate(int64(newSize))
-		if err != nil {
-			return fmt.Errorf("could not truncate data file to new size %d: %w", newSize, err)
-		}
-		err = sm.readOnlyData.Unmap()
-		if err != nil {
-			return fmt.Errorf("could not unmap data mmap: %w", err)
-		}
-		readOnlyData, err := mmap.Map(sm.data, mmap.RDONLY, 0)
-		if err != nil {
-			return fmt.Errorf("could not create resized read only data mmap: %w", err)
-		}
+// stage writes the entries after the first count entries, without making them visible to readers.
+func (sm *StateMate[T]) stage(count uint64, entries []Entry[T]) error {
+	entries, err := sm.encrypt(entries)
+	if err != nil {
+		return err
+	}
 
-		sm.readOnlyData = readOnlyData
+	endOfLastData := sm.dataStart(count)
+	hasLast := count > 0
+	lastIndex := T(0)
+	if hasLast {
+		lastIndex = sm.indexAt(count - 1)
+	}
+
+	dataSize := uint64(0)
+	for _, e := range entries {
+		if hasLast {
+			if lastIndex >= e.Index {
+				return ErrIndexMustBeIncreasing
+			}
 
+			if lastIndex+1 != e.Index && !sm.options.AllowGaps {
+				return ErrIndexGapsAreNotAllowed
+			}
+		}
+		hasLast = true
+		lastIndex = e.Index
+		dataSize += uint64(len(e.Data))
 	}
 
-	sizeOfIndex := (count * 16) + 8
-	availableForIndex := len(sm.readOnlyIndex) - int(sizeOfIndex)
+	available := len(sm.payload()) - int(endOfLastData)
 
-	if availableForIndex < 16 {
-		newSize, err := calculateNewSize(sizeOfIndex, uint64(availableForIndex), 16, math.MaxUint64)
+	if available <= int(dataSize) {
+		newSize, err := calculateNewSize(endOfLastData, uint64(available), dataSize, sm.options.GetMaxSize())
 		if err != nil {
 			return err
 		}
-		err = sm.index.Truncate(int64(newSize))
-		if err != nil {
-			return fmt.Errorf("could not truncate index file to new size %d: %w", newSize, err)
-		}
-		err = sm.readOnlyIndex.Unmap()
-		if err != nil {
-			return fmt.Errorf("could not unmap index mmap: %w", err)
-		}
-		readOnlyIndex, err := mmap.Map(sm.index, mmap.RDONLY, 0)
+
+		err = sm.resizeData(sm.dataHeaderSize + newSize)
 		if err != nil {
-			return fmt.Errorf("could not create resized read only data mmap: %w", err)
+			return err
 		}
-
-		sm.readOnlyIndex = readOnlyIndex
-
-	}
-
-	dataWriteMap, err := mmap.Map(sm.data, mmap.RDWR, 0)
-	if err != nil {
-		return fmt.Errorf("could not create data RW mmap: %w", err)
 	}
 
-	copy(dataWriteMap[endOfLastData:], data)
+	sizeOfIndex := sm.indexSize(count)
+	availableForIndex := len(sm.index) - int(sizeOfIndex)
+	indexSpaceNeeded := uint64(len(entries)) * sm.recordSize
 
-	err = dataWriteMap.Unmap()
-	if err != nil {
-		return fmt.Errorf("could not unmap data RW map: %w", err)
-	}
-
-	endOfLastData += uint64(len(data))
+	if availableForIndex < int(indexSpaceNeeded) {
+		newSize, err := calculateNewSize(sizeOfIndex, uint64(availableForIndex), indexSpaceNeeded, math.MaxUint64)
+		if err != nil {
+			return err
+		}
 
-	indexWriteMap, err := mmap.Map(sm.index, mmap.RDWR, 0)
-	if err != nil {
-		return fmt.Errorf("could not create index RW mmap: %w", err)
+		err = sm.resizeIndex(newSize)
+		if err != nil {
+			return err
+		}
 	}
 
-	binary.BigEndian.PutUint64(indexWriteMap[sizeOfIndex:], uint64(index))
-	binary.BigEndian.PutUint64(indexWriteMap[sizeOfIndex+8:], endOfLastData)
-
-	binary.BigEndian.PutUint64(indexWriteMap, count+1)
+	for i, e := range entries {
+		copy(sm.payload()[endOfLastData:], e.Data)
+		endOfLastData += uint64(len(e.Data))
 
-	err = indexWriteMap.Unmap()
-	if err != nil {
-		return fmt.Errorf("could not unmap index RW map: %w", err)
+		sm.putRecord(count+uint64(i), e.Index, endOfLastData, e.Data)
 	}
 
 	return nil
@@ -259,18 +544,15 @@ func (sm *StateMate[T]) StorageStats() StorageStats {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	count := binary.BigEndian.Uint64(sm.readOnlyIndex[:8])
-
-	endOfLastData := uint64(0)
-	if count > 0 {
-		endOfLastData = binary.BigEndian.Uint64(sm.readOnlyIndex[8:][(count-1)*16+8:])
+	if sm.closed {
+		return StorageStats{}
 	}
 
 	return StorageStats{
-		DataSize:      endOfLastData,
-		IndexSize:     (count * 16) + 8,
-		DataFileSize:  uint64(len(sm.readOnlyData)),
-		IndexFileSize: uint64(len(sm.readOnlyIndex)),
+		DataSize:      sm.dataEnd(),
+		IndexSize:     sm.indexSize(sm.count),
+		DataFileSize:  uint64(len(sm.data)),
+		IndexFileSize: uint64(len(sm.index)),
 	}
 
 }
@@ -278,111 +560,190 @@ func (sm *StateMate[T]) StorageStats() StorageStats {
 // Truncate removes all the padding at the end of the data and index files.
 // This method should be called before the state should be archived.
 func (sm *StateMate[T]) Truncate() error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+
+	sm.writeMu.Lock()
+	defer sm.writeMu.Unlock()
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	count := binary.BigEndian.Uint64(sm.readOnlyIndex[:8])
+	if sm.closed {
+		return ErrClosed
+	}
 
-	endOfLastData := uint64(0)
-	if count > 0 {
-		endOfLastData = binary.BigEndian.Uint64(sm.readOnlyIndex[8:][(count-1)*16+8:])
+	if sm.count != sm.persistedCount {
+		err := sm.sync()
+		if err != nil {
+			return err
+		}
 	}
 
-	available := len(sm.readOnlyData) - int(endOfLastData)
+	endOfLastData := sm.dataEnd()
+
+	available := len(sm.payload()) - int(endOfLastData)
 
 	if available > 0 {
-		if endOfLastData == 0 {
+		newSize := sm.dataHeaderSize + endOfLastData
+		if newSize == 0 {
 			// reduce file to 1 byte instead of 0
 			// mmapping empty files in macOS leads to mmap: invalid argument
-			endOfLastData = 1
-		}
-		err := sm.data.Truncate(int64(endOfLastData))
-		if err != nil {
-			return fmt.Errorf("could not truncate data file to new size %d: %w", endOfLastData, err)
+			newSize = 1
 		}
-		err = sm.readOnlyData.Unmap()
+		err := sm.resizeData(newSize)
 		if err != nil {
-			return fmt.Errorf("could not unmap data mmap: %w", err)
-		}
-		readOnlyData, err := mmap.Map(sm.data, mmap.RDONLY, 0)
-		if err != nil {
-			return fmt.Errorf("could not create resized read only data mmap: %w", err)
+			return err
 		}
-
-		sm.readOnlyData = readOnlyData
 	}
 
-	sizeOfIndex := (count * 16) + 8
-	availableForIndex := len(sm.readOnlyIndex) - int(sizeOfIndex)
+	sizeOfIndex := sm.indexSize(sm.count)
+	availableForIndex := len(sm.index) - int(sizeOfIndex)
 
 	if availableForIndex > 0 {
-		err := sm.index.Truncate(int64(sizeOfIndex))
+		err := sm.resizeIndex(sizeOfIndex)
 		if err != nil {
-			return fmt.Errorf("could not truncate index file to new size %d: %w", sizeOfIndex, err)
-		}
-		err = sm.readOnlyIndex.Unmap()
-		if err != nil {
-			return fmt.Errorf("could not unmap index mmap: %w", err)
+			return err
 		}
-		readOnlyIndex, err := mmap.Map(sm.index, mmap.RDONLY, 0)
+	}
+
+	return nil
+}
+
+// TruncateAfter removes all entries with an index greater than index.
+// It returns ErrSnapshotsOpen while snapshots are open, because appended entries would overwrite their data.
+// The space used by the removed entries is reused by subsequent appends,
+// call Truncate to shrink the files.
+// The new entry count is persisted before TruncateAfter returns,
+// so removed entries are not resurrected after a crash.
+func (sm *StateMate[T]) TruncateAfter(index T) error {
+	if sm.readOnly {
+		return ErrReadOnly
+	}
+
+	sm.writeMu.Lock()
+	defer sm.writeMu.Unlock()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.closed {
+		return ErrClosed
+	}
+
+	if sm.snapshots > 0 {
+		return ErrSnapshotsOpen
+	}
+
+	count := uint64(sort.Search(int(sm.count), func(i int) bool {
+		return sm.indexAt(uint64(i)) > index
+	}))
+
+	if count == sm.count {
+		return nil
+	}
+
+	sm.count = count
+	sm.truncatedSubscribers(count)
+	// followers read the truncation before the count, so it is recorded first
+	sm.recordTruncation(count)
+
+	if sm.options.Sync == SyncNone {
+		// only the header is flushed, the remaining entries are left to the operating system as on append
+		sm.setHeaderCount(count)
+
+		err := sm.index.Flush()
 		if err != nil {
-			return fmt.Errorf("could not create resized read only data mmap: %w", err)
+			return fmt.Errorf("could not flush index header: %w", err)
 		}
 
-		sm.readOnlyIndex = readOnlyIndex
-
+		sm.persistedCount = count
+		return nil
 	}
 
-	return nil
+	return sm.sync()
 }
 
 var ErrNotFound = errors.New("not found")
 
+// Read calls fn with the data of the entry with the given index or returns ErrNotFound.
+// The data slice is backed by the mapped data file: it must not be modified and is only valid until fn returns.
 func (sm *StateMate[T]) Read(index T, fn func(data []byte) error) error {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
-	count := binary.BigEndian.Uint64(sm.readOnlyIndex[:8])
 
-	searchSlice := sm.readOnlyIndex[8:]
+	if sm.closed {
+		return ErrClosed
+	}
 
-	indexOf := func(n int) T {
-		return T(binary.BigEndian.Uint64(searchSlice[n*16:]))
+	pos, found := sm.find(index)
+	if !found {
+		return ErrNotFound
 	}
 
-	indexPos, found := sort.Find(int(count), func(i int) int {
-		iind := indexOf(i)
-		if index < iind {
-			return -1
-		}
-		if index > iind {
-			return 1
-		}
+	data, err := sm.entryData(pos)
+	if err != nil {
+		return err
+	}
 
-		return 0
+	data, _, err = sm.decode(index, data, nil)
+	if err != nil {
+		return err
+	}
 
-	})
+	return fn(data)
 
-	if !found {
-		return ErrNotFound
+}
+
+// Range calls fn for every entry with an index between from and to (both inclusive), in ascending order.
+// The entries are walked sequentially under a single read lock, so missing indices of stores
+// with AllowGaps are skipped without being looked up.
+// Iteration stops at the first error returned by fn or checksum mismatch and that error is returned.
+// The data slice is only valid until fn returns and fn must not call methods acquiring the write lock, such as Append.
+func (sm *StateMate[T]) Range(from, to T, fn func(index T, data []byte) error) error {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if sm.closed {
+		return ErrClosed
 	}
 
-	endPos := binary.BigEndian.Uint64(searchSlice[indexPos*16+8:])
-	startPos := uint64(0)
-	if indexPos != 0 {
-		startPos = binary.BigEndian.Uint64(searchSlice[(indexPos-1)*16+8:])
+	count := sm.count
+
+	var buf []byte
+	for pos := sm.search(from); pos < count; pos++ {
+		index := sm.indexAt(pos)
+		if index > to {
+			break
+		}
+
+		data, err := sm.entryData(pos)
+		if err != nil {
+			return err
+		}
+
+		data, buf, err = sm.decode(index, data, buf)
+		if err != nil {
+			return err
+		}
+
+		err = fn(index, data)
+		if err != nil {
+			return err
+		}
 	}
 
-	return fn(sm.readOnlyData[startPos:endPos])
+	return nil
 
 }
 
+// IsEmpty reports whether the store has no entries, closed stores are empty.
 func (sm *StateMate[T]) IsEmpty() bool {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	count := binary.BigEndian.Uint64(sm.readOnlyIndex[:8])
-
-	return count == 0
+	return sm.count == 0 || sm.closed
 
 }
 
@@ -390,13 +751,7 @@ func (sm *StateMate[T]) GetLastIndex() T {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	count := binary.BigEndian.Uint64(sm.readOnlyIndex[:8])
-
-	if count == 0 {
-		return T(uint64(math.MaxUint64))
-	}
-
-	return T(binary.BigEndian.Uint64(sm.readOnlyIndex[8:][(count-1)*16:]))
+	return sm.lastIndex()
 
 }
 
@@ -404,22 +759,23 @@ func (sm *StateMate[T]) GetFirstIndex() T {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	count := binary.BigEndian.Uint64(sm.readOnlyIndex[:8])
-
-	if count == 0 {
+	if sm.count == 0 || sm.closed {
 		return T(uint64(math.MaxUint64))
 	}
 
-	return T(binary.BigEndian.Uint64(sm.readOnlyIndex[8:]))
+	return sm.indexAt(0)
 
 }
 
+// Count returns the number of entries, closed stores have no entries.
 func (sm *StateMate[T]) Count() uint64 {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	count := binary.BigEndian.Uint64(sm.readOnlyIndex[:8])
+	if sm.closed {
+		return 0
+	}
 
-	return count
+	return sm.count
 
 }
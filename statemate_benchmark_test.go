@@ -0,0 +1,77 @@
+package statemate_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/draganm/statemate"
+)
+
+func openForBenchmark(b *testing.B) *statemate.StateMate[uint64] {
+	sm, err := statemate.Open[uint64](filepath.Join(b.TempDir(), "state"), statemate.Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		err := sm.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	})
+	return sm
+}
+
+func BenchmarkAppend(b *testing.B) {
+	sm := openForBenchmark(b)
+	data := make([]byte, 128)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := sm.Append(uint64(i), data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendBatch(b *testing.B) {
+	sm := openForBenchmark(b)
+	data := make([]byte, 128)
+	batch := make([]statemate.Entry[uint64], 100)
+	b.SetBytes(int64(len(data) * len(batch)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for j := range batch {
+			batch[j] = statemate.Entry[uint64]{Index: uint64(i*len(batch) + j), Data: data}
+		}
+		err := sm.AppendBatch(batch)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	sm := openForBenchmark(b)
+	data := make([]byte, 128)
+	const entries = 10000
+	for i := 0; i < entries; i++ {
+		err := sm.Append(uint64(i), data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := sm.Read(uint64(i%entries), func(data []byte) error {
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
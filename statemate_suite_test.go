@@ -1,10 +1,18 @@
 package statemate_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
 	"math"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/draganm/statemate"
 	. "github.com/onsi/ginkgo/v2"
@@ -17,6 +25,40 @@ func TestStateMate(t *testing.T) {
 	RunSpecs(t, "Statemate Suite", types.ReporterConfig{NoColor: true})
 }
 
+// reverseCodec is a custom codec storing the data reversed.
+type reverseCodec struct{}
+
+func (reverseCodec) ID() uint8 {
+	return 200
+}
+
+func (reverseCodec) Compress(dst, src []byte) ([]byte, error) {
+	for i := len(src) - 1; i >= 0; i-- {
+		dst = append(dst, src[i])
+	}
+	return dst, nil
+}
+
+func (c reverseCodec) Decompress(dst, src []byte) ([]byte, error) {
+	return c.Compress(dst, src)
+}
+
+// countingCodec counts the calls to Decompress of the wrapped codec.
+type countingCodec struct {
+	statemate.Codec
+	decompressed *atomic.Int64
+}
+
+func (c countingCodec) Decompress(dst, src []byte) ([]byte, error) {
+	c.decompressed.Add(1)
+	return c.Codec.Decompress(dst, src)
+}
+
+func must[T any](v T, err error) T {
+	Expect(err).ToNot(HaveOccurred())
+	return v
+}
+
 var _ = Describe("Statemate", func() {
 
 	var tempDir string
@@ -288,9 +330,9 @@ var _ = Describe("Statemate", func() {
 			It("should return 0 for all fields", func() {
 				stats := sm.StorageStats()
 				Expect(stats.DataSize).To(Equal(uint64(0)))
-				Expect(stats.IndexSize).To(Equal(uint64(8)))
-				Expect(stats.DataFileSize).To(Equal(uint64(1)))
-				Expect(stats.IndexFileSize).To(Equal(uint64(8)))
+				Expect(stats.IndexSize).To(Equal(uint64(64)))
+				Expect(stats.DataFileSize).To(Equal(uint64(16)))
+				Expect(stats.IndexFileSize).To(Equal(uint64(64)))
 			})
 		})
 		When("statemate has one element", func() {
@@ -301,9 +343,9 @@ var _ = Describe("Statemate", func() {
 			It("should return the size of the data", func() {
 				stats := sm.StorageStats()
 				Expect(stats.DataSize).To(Equal(uint64(1)))
-				Expect(stats.IndexSize).To(Equal(uint64(24)))
-				Expect(stats.DataFileSize).To(Equal(uint64(1)))
-				Expect(stats.IndexFileSize).To(Equal(uint64(36)))
+				Expect(stats.IndexSize).To(Equal(uint64(80)))
+				Expect(stats.DataFileSize).To(Equal(uint64(17)))
+				Expect(stats.IndexFileSize).To(Equal(uint64(120)))
 			})
 
 			When("I add another element", func() {
@@ -314,9 +356,9 @@ var _ = Describe("Statemate", func() {
 				It("should return the size of the data", func() {
 					stats := sm.StorageStats()
 					Expect(stats.DataSize).To(Equal(uint64(2)))
-					Expect(stats.IndexSize).To(Equal(uint64(40)))
-					Expect(stats.DataFileSize).To(Equal(uint64(3)))
-					Expect(stats.IndexFileSize).To(Equal(uint64(60)))
+					Expect(stats.IndexSize).To(Equal(uint64(96)))
+					Expect(stats.DataFileSize).To(Equal(uint64(19)))
+					Expect(stats.IndexFileSize).To(Equal(uint64(120)))
 				})
 			})
 		})
@@ -484,4 +526,2644 @@ var _ = Describe("Statemate", func() {
 			})
 		})
 	})
+
+	Describe("Range", func() {
+		type entry struct {
+			index uint64
+			data  []byte
+		}
+
+		var sm *statemate.StateMate[uint64]
+		var entries []entry
+		collect := func(index uint64, data []byte) error {
+			d := make([]byte, len(data))
+			copy(d, data)
+			entries = append(entries, entry{index: index, data: d})
+			return nil
+		}
+
+		BeforeEach(func() {
+			entries = nil
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), statemate.Options{AllowGaps: true})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				err := sm.Close()
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("when statemate is empty", func() {
+			It("should not call the callback", func() {
+				err := sm.Range(0, math.MaxUint64, collect)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(BeEmpty())
+			})
+		})
+
+		Context("when there are entries with gaps", func() {
+			BeforeEach(func() {
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Expect(sm.Append(3, []byte{3, 3})).To(Succeed())
+				Expect(sm.Append(7, []byte{7})).To(Succeed())
+				Expect(sm.Append(9, []byte{9, 9, 9})).To(Succeed())
+			})
+
+			It("should return all entries in order", func() {
+				err := sm.Range(0, math.MaxUint64, collect)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(Equal([]entry{
+					{index: 2, data: []byte{2}},
+					{index: 3, data: []byte{3, 3}},
+					{index: 7, data: []byte{7}},
+					{index: 9, data: []byte{9, 9, 9}},
+				}))
+			})
+
+			It("should return only the entries within the bounds", func() {
+				err := sm.Range(3, 8, collect)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(Equal([]entry{
+					{index: 3, data: []byte{3, 3}},
+					{index: 7, data: []byte{7}},
+				}))
+			})
+
+			It("should start with the first entry after a missing from index", func() {
+				err := sm.Range(4, 9, collect)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(Equal([]entry{
+					{index: 7, data: []byte{7}},
+					{index: 9, data: []byte{9, 9, 9}},
+				}))
+			})
+
+			It("should not call the callback when from is greater than to", func() {
+				err := sm.Range(9, 2, collect)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(BeEmpty())
+			})
+
+			It("should stop and return the error of the callback", func() {
+				stop := errors.New("stop")
+				calls := 0
+				err := sm.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+					calls++
+					return stop
+				})
+				Expect(err).To(Equal(stop))
+				Expect(calls).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("iterators", func() {
+		var sm *statemate.StateMate[uint64]
+
+		BeforeEach(func() {
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), statemate.Options{AllowGaps: true})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				err := sm.Close()
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		collect := func(seq iter.Seq2[uint64, []byte]) map[uint64][]byte {
+			m := map[uint64][]byte{}
+			for index, data := range seq {
+				d := make([]byte, len(data))
+				copy(d, data)
+				m[index] = d
+			}
+			return m
+		}
+
+		keys := func(seq iter.Seq2[uint64, []byte]) []uint64 {
+			k := []uint64{}
+			for index := range seq {
+				k = append(k, index)
+			}
+			return k
+		}
+
+		Context("when statemate is empty", func() {
+			It("should not yield any entries", func() {
+				Expect(keys(sm.All())).To(BeEmpty())
+				Expect(keys(sm.Backward())).To(BeEmpty())
+				Expect(keys(sm.Between(0, 10))).To(BeEmpty())
+			})
+		})
+
+		Context("when there are entries with gaps", func() {
+			BeforeEach(func() {
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Expect(sm.Append(3, []byte{3, 3})).To(Succeed())
+				Expect(sm.Append(7, []byte{7})).To(Succeed())
+			})
+
+			It("All should yield all entries in ascending order", func() {
+				Expect(keys(sm.All())).To(Equal([]uint64{2, 3, 7}))
+				Expect(collect(sm.All())).To(Equal(map[uint64][]byte{
+					2: {2},
+					3: {3, 3},
+					7: {7},
+				}))
+			})
+
+			It("Backward should yield all entries in descending order", func() {
+				Expect(keys(sm.Backward())).To(Equal([]uint64{7, 3, 2}))
+				Expect(collect(sm.Backward())).To(Equal(map[uint64][]byte{
+					2: {2},
+					3: {3, 3},
+					7: {7},
+				}))
+			})
+
+			It("Between should yield only the entries within the bounds", func() {
+				Expect(keys(sm.Between(3, 7))).To(Equal([]uint64{3, 7}))
+				Expect(keys(sm.Between(4, 6))).To(BeEmpty())
+			})
+
+			It("Entries should yield the entries within the bounds without errors", func() {
+				entries := []statemate.Entry[uint64]{}
+				for e, err := range sm.Entries(3, 10) {
+					Expect(err).ToNot(HaveOccurred())
+					entries = append(entries, statemate.Entry[uint64]{Index: e.Index, Data: bytes.Clone(e.Data)})
+				}
+				Expect(entries).To(Equal([]statemate.Entry[uint64]{
+					{Index: 3, Data: []byte{3, 3}},
+					{Index: 7, Data: []byte{7}},
+				}))
+			})
+
+			It("should stop when the loop is terminated early", func() {
+				k := []uint64{}
+				for index := range sm.All() {
+					k = append(k, index)
+					break
+				}
+				Expect(k).To(Equal([]uint64{2}))
+
+				k = []uint64{}
+				for index := range sm.Backward() {
+					k = append(k, index)
+					break
+				}
+				Expect(k).To(Equal([]uint64{7}))
+			})
+
+			It("should release the read lock after a terminated loop", func() {
+				for range sm.All() {
+					break
+				}
+				Expect(sm.Append(8, []byte{8})).To(Succeed())
+			})
+		})
+	})
+
+	Describe("AppendBatch", func() {
+		var sm *statemate.StateMate[uint64]
+		BeforeEach(func() {
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				err := sm.Close()
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		readAll := func() map[uint64][]byte {
+			m := map[uint64][]byte{}
+			err := sm.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+				d := make([]byte, len(data))
+				copy(d, data)
+				m[index] = d
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return m
+		}
+
+		Context("when the batch is empty", func() {
+			It("should not return an error", func() {
+				Expect(sm.AppendBatch(nil)).To(Succeed())
+				Expect(sm.IsEmpty()).To(BeTrue())
+			})
+		})
+
+		Context("when I append a batch of entries", func() {
+			var err error
+			BeforeEach(func() {
+				err = sm.AppendBatch([]statemate.Entry[uint64]{
+					{Index: 1, Data: []byte{1}},
+					{Index: 2, Data: []byte{2, 2}},
+					{Index: 3, Data: []byte{3, 3, 3}},
+				})
+			})
+
+			It("should not return an error", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should store all entries", func() {
+				Expect(sm.Count()).To(Equal(uint64(3)))
+				Expect(readAll()).To(Equal(map[uint64][]byte{
+					1: {1},
+					2: {2, 2},
+					3: {3, 3, 3},
+				}))
+			})
+
+			Context("when I append another batch", func() {
+				BeforeEach(func() {
+					err = sm.AppendBatch([]statemate.Entry[uint64]{
+						{Index: 4, Data: []byte{4}},
+						{Index: 5, Data: []byte{}},
+					})
+				})
+
+				It("should append the entries after the existing ones", func() {
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sm.GetLastIndex()).To(Equal(uint64(5)))
+					Expect(readAll()).To(HaveLen(5))
+				})
+			})
+
+			Context("when the batch is not increasing", func() {
+				BeforeEach(func() {
+					err = sm.AppendBatch([]statemate.Entry[uint64]{
+						{Index: 4, Data: []byte{4}},
+						{Index: 4, Data: []byte{4}},
+					})
+				})
+
+				It("should return an error and not append any entry", func() {
+					Expect(err).To(Equal(statemate.ErrIndexMustBeIncreasing))
+					Expect(sm.Count()).To(Equal(uint64(3)))
+				})
+			})
+
+			Context("when the batch contains a gap", func() {
+				BeforeEach(func() {
+					err = sm.AppendBatch([]statemate.Entry[uint64]{
+						{Index: 4, Data: []byte{4}},
+						{Index: 6, Data: []byte{6}},
+					})
+				})
+
+				It("should return an error and not append any entry", func() {
+					Expect(err).To(Equal(statemate.ErrIndexGapsAreNotAllowed))
+					Expect(sm.Count()).To(Equal(uint64(3)))
+				})
+			})
+		})
+	})
+
+	Describe("Close", func() {
+		var sm *statemate.StateMate[uint64]
+
+		BeforeEach(func() {
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sm.Append(1, []byte{1})).To(Succeed())
+			Expect(sm.Close()).To(Succeed())
+		})
+
+		It("should return ErrClosed from methods using the files", func() {
+			Expect(sm.Append(2, []byte{2})).To(MatchError(statemate.ErrClosed))
+			_, err := sm.AppendNext([]byte{2})
+			Expect(err).To(MatchError(statemate.ErrClosed))
+			Expect(sm.Read(1, func([]byte) error { return nil })).To(MatchError(statemate.ErrClosed))
+			Expect(sm.Range(0, math.MaxUint64, func(uint64, []byte) error { return nil })).To(MatchError(statemate.ErrClosed))
+			Expect(sm.TruncateAfter(0)).To(MatchError(statemate.ErrClosed))
+			Expect(sm.Truncate()).To(MatchError(statemate.ErrClosed))
+			Expect(sm.PruneBefore(1)).To(MatchError(statemate.ErrClosed))
+			Expect(sm.Sync()).To(MatchError(statemate.ErrClosed))
+			Expect(sm.Close()).To(MatchError(statemate.ErrClosed))
+		})
+
+		It("should not access the files from methods without errors", func() {
+			Expect(sm.Count()).To(Equal(uint64(0)))
+			Expect(sm.IsEmpty()).To(BeTrue())
+			Expect(sm.GetFirstIndex()).To(Equal(uint64(math.MaxUint64)))
+			Expect(sm.GetLastIndex()).To(Equal(uint64(math.MaxUint64)))
+			Expect(sm.StorageStats()).To(Equal(statemate.StorageStats{}))
+			for range sm.All() {
+				Fail("iterated over a closed store")
+			}
+			for _, err := range sm.Entries(0, math.MaxUint64) {
+				Expect(err).To(MatchError(statemate.ErrClosed))
+			}
+		})
+	})
+
+	Describe("Sync", func() {
+		var sm *statemate.StateMate[uint64]
+		var options statemate.Options
+
+		headerCount := func() uint64 {
+			d, err := os.ReadFile(filepath.Join(tempDir, "state.idx"))
+			Expect(err).ToNot(HaveOccurred())
+			return binary.BigEndian.Uint64(d[16:])
+		}
+
+		JustBeforeEach(func() {
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), options)
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				err := sm.Close()
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		When("sync mode is SyncNone", func() {
+			BeforeEach(func() {
+				options = statemate.Options{}
+			})
+
+			It("should update the header on every append", func() {
+				Expect(sm.Append(1, []byte{1})).To(Succeed())
+				Expect(headerCount()).To(Equal(uint64(1)))
+			})
+		})
+
+		When("sync mode is SyncEveryAppend", func() {
+			BeforeEach(func() {
+				options = statemate.Options{Sync: statemate.SyncEveryAppend}
+			})
+
+			It("should update the header on every append", func() {
+				Expect(sm.Append(1, []byte{1})).To(Succeed())
+				Expect(headerCount()).To(Equal(uint64(1)))
+				Expect(sm.AppendBatch([]statemate.Entry[uint64]{{Index: 2}, {Index: 3}})).To(Succeed())
+				Expect(headerCount()).To(Equal(uint64(3)))
+			})
+		})
+
+		When("sync mode is SyncEveryN", func() {
+			BeforeEach(func() {
+				options = statemate.Options{Sync: statemate.SyncEveryN, SyncEvery: 3}
+			})
+
+			It("should update the header after every N appended entries", func() {
+				Expect(sm.Append(1, []byte{1})).To(Succeed())
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Expect(headerCount()).To(Equal(uint64(0)))
+				Expect(sm.Count()).To(Equal(uint64(2)))
+				Expect(sm.Append(3, []byte{3})).To(Succeed())
+				Expect(headerCount()).To(Equal(uint64(3)))
+			})
+		})
+
+		When("sync mode is SyncInterval", func() {
+			BeforeEach(func() {
+				options = statemate.Options{Sync: statemate.SyncInterval, SyncInterval: 10 * time.Millisecond}
+			})
+
+			It("should update the header after the interval", func() {
+				Expect(sm.Append(1, []byte{1})).To(Succeed())
+				Eventually(headerCount).Should(Equal(uint64(1)))
+				Expect(sm.Sync()).To(Succeed())
+			})
+		})
+
+		When("sync mode is SyncManual", func() {
+			BeforeEach(func() {
+				options = statemate.Options{Sync: statemate.SyncManual}
+			})
+
+			It("should update the header only when Sync is called", func() {
+				Expect(sm.Append(1, []byte{1})).To(Succeed())
+				Expect(headerCount()).To(Equal(uint64(0)))
+
+				var d []byte
+				err := sm.Read(1, func(data []byte) error {
+					d = append(d, data...)
+					return nil
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(d).To(Equal([]byte{1}))
+
+				Expect(sm.Sync()).To(Succeed())
+				Expect(headerCount()).To(Equal(uint64(1)))
+			})
+
+			It("should persist the appended entries on Close", func() {
+				Expect(sm.Append(1, []byte{1})).To(Succeed())
+				Expect(sm.Close()).To(Succeed())
+				Expect(headerCount()).To(Equal(uint64(1)))
+
+				var err error
+				sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), options)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sm.Count()).To(Equal(uint64(1)))
+			})
+		})
+	})
+
+	Describe("opening a corrupted store", func() {
+		var options statemate.Options
+		var sm *statemate.StateMate[uint64]
+		var err error
+
+		indexFileName := func() string {
+			return filepath.Join(tempDir, "state.idx")
+		}
+
+		modifyIndex := func(fn func(idx []byte)) {
+			d, err := os.ReadFile(indexFileName())
+			Expect(err).ToNot(HaveOccurred())
+			fn(d)
+			err = os.WriteFile(indexFileName(), d, 0700)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			options = statemate.Options{}
+			sm, err := statemate.Open[uint64](filepath.Join(tempDir, "state"), options)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sm.Append(1, []byte{1})).To(Succeed())
+			Expect(sm.Append(2, []byte{2, 2})).To(Succeed())
+			Expect(sm.Append(3, []byte{3, 3, 3})).To(Succeed())
+			Expect(sm.Close()).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), options)
+			if err == nil {
+				DeferCleanup(func() {
+					err := sm.Close()
+					Expect(err).ToNot(HaveOccurred())
+				})
+			}
+		})
+
+		When("the index is consistent", func() {
+			It("should open the store", func() {
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sm.Count()).To(Equal(uint64(3)))
+			})
+		})
+
+		When("an entry points beyond the end of the data file", func() {
+			BeforeEach(func() {
+				modifyIndex(func(idx []byte) {
+					binary.BigEndian.PutUint64(idx[64+2*16+8:], 1000)
+				})
+			})
+
+			It("should return a CorruptedError", func() {
+				Expect(err).To(MatchError(statemate.ErrCorrupted))
+				var corrupted *statemate.CorruptedError
+				Expect(errors.As(err, &corrupted)).To(BeTrue())
+				Expect(corrupted.Position).To(Equal(uint64(2)))
+				Expect(corrupted.Count).To(Equal(uint64(3)))
+			})
+
+			When("Repair option is set", func() {
+				BeforeEach(func() {
+					options.Repair = true
+				})
+
+				It("should roll back to the last consistent entry", func() {
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sm.Count()).To(Equal(uint64(2)))
+					Expect(sm.GetLastIndex()).To(Equal(uint64(2)))
+					Expect(sm.Append(3, []byte{4})).To(Succeed())
+				})
+			})
+		})
+
+		When("indices are not increasing", func() {
+			BeforeEach(func() {
+				modifyIndex(func(idx []byte) {
+					binary.BigEndian.PutUint64(idx[64+1*16:], 0)
+				})
+			})
+
+			It("should return a CorruptedError", func() {
+				var corrupted *statemate.CorruptedError
+				Expect(errors.As(err, &corrupted)).To(BeTrue())
+				Expect(corrupted.Position).To(Equal(uint64(1)))
+			})
+		})
+
+		When("data offsets are decreasing", func() {
+			BeforeEach(func() {
+				modifyIndex(func(idx []byte) {
+					binary.BigEndian.PutUint64(idx[64+1*16+8:], 0)
+				})
+			})
+
+			It("should return a CorruptedError", func() {
+				var corrupted *statemate.CorruptedError
+				Expect(errors.As(err, &corrupted)).To(BeTrue())
+				Expect(corrupted.Position).To(Equal(uint64(1)))
+			})
+		})
+
+		When("the header counts more entries than the index file holds", func() {
+			BeforeEach(func() {
+				modifyIndex(func(idx []byte) {
+					binary.BigEndian.PutUint64(idx[16:], 1000)
+				})
+			})
+
+			It("should return a CorruptedError", func() {
+				Expect(err).To(MatchError(statemate.ErrCorrupted))
+			})
+
+			When("Repair option is set", func() {
+				BeforeEach(func() {
+					options.Repair = true
+				})
+
+				It("should roll back to the last consistent entry", func() {
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sm.GetLastIndex()).To(Equal(uint64(3)))
+				})
+			})
+		})
+
+		When("the size of the counted entries overflows", func() {
+			BeforeEach(func() {
+				modifyIndex(func(idx []byte) {
+					binary.BigEndian.PutUint64(idx[16:], 1<<60+3)
+				})
+			})
+
+			It("should return a CorruptedError", func() {
+				Expect(err).To(MatchError(statemate.ErrCorrupted))
+			})
+
+			When("Repair option is set", func() {
+				BeforeEach(func() {
+					options.Repair = true
+				})
+
+				It("should roll back to the last consistent entry", func() {
+					Expect(err).ToNot(HaveOccurred())
+					Expect(sm.GetLastIndex()).To(Equal(uint64(3)))
+				})
+			})
+		})
+	})
+
+	Describe("Checksums", func() {
+		var sm *statemate.StateMate[uint64]
+		BeforeEach(func() {
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), statemate.Options{Checksums: true})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				err := sm.Close()
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			Expect(sm.Append(1, []byte{1, 2, 3})).To(Succeed())
+			Expect(sm.Append(2, []byte{4, 5, 6})).To(Succeed())
+		})
+
+		It("should store a checksum in every index record", func() {
+			Expect(sm.StorageStats().IndexSize).To(Equal(uint64(64 + 2*20)))
+		})
+
+		When("the data is intact", func() {
+			It("should read the data", func() {
+				var d []byte
+				err := sm.Read(2, func(data []byte) error {
+					d = append(d, data...)
+					return nil
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(d).To(Equal([]byte{4, 5, 6}))
+			})
+		})
+
+		When("the data has been modified on disk", func() {
+			BeforeEach(func() {
+				f, err := os.OpenFile(filepath.Join(tempDir, "state"), os.O_RDWR, 0700)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = f.WriteAt([]byte{7}, 16+4)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+			})
+
+			It("Read should return ErrChecksumMismatch", func() {
+				err := sm.Read(2, func(data []byte) error {
+					return nil
+				})
+				Expect(err).To(MatchError(statemate.ErrChecksumMismatch))
+			})
+
+			It("Read should return unmodified entries", func() {
+				err := sm.Read(1, func(data []byte) error {
+					return nil
+				})
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("Range should return ErrChecksumMismatch", func() {
+				err := sm.Range(0, 10, func(index uint64, data []byte) error {
+					return nil
+				})
+				Expect(err).To(MatchError(statemate.ErrChecksumMismatch))
+			})
+
+			It("iterators should stop at the modified entry", func() {
+				indices := []uint64{}
+				for index := range sm.All() {
+					indices = append(indices, index)
+				}
+				Expect(indices).To(Equal([]uint64{1}))
+
+				for range sm.Backward() {
+					Fail("yielded a modified entry")
+				}
+			})
+
+			It("Entries should report ErrChecksumMismatch for the modified entry", func() {
+				indices := []uint64{}
+				var iterErr error
+				for e, err := range sm.Entries(0, 10) {
+					indices = append(indices, e.Index)
+					iterErr = err
+				}
+				Expect(indices).To(Equal([]uint64{1, 2}))
+				Expect(iterErr).To(MatchError(statemate.ErrChecksumMismatch))
+			})
+		})
+	})
+
+	Describe("file format", func() {
+		var stateFile string
+		BeforeEach(func() {
+			stateFile = filepath.Join(tempDir, "state")
+		})
+
+		readAll := func(sm *statemate.StateMate[uint64]) map[uint64][]byte {
+			m := map[uint64][]byte{}
+			err := sm.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+				d := make([]byte, len(data))
+				copy(d, data)
+				m[index] = d
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return m
+		}
+
+		When("a new store is created", func() {
+			BeforeEach(func() {
+				sm, err := statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sm.Close()).To(Succeed())
+			})
+
+			It("should write headers with magic bytes", func() {
+				d, err := os.ReadFile(stateFile)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(d[:4])).To(Equal("SMDT"))
+
+				idx, err := os.ReadFile(stateFile + ".idx")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(idx[:4])).To(Equal("SMIX"))
+				Expect(binary.BigEndian.Uint16(idx[4:])).To(Equal(uint16(1)))
+				Expect(idx[6]).To(Equal(byte(8)))
+			})
+		})
+
+		When("the store was created with checksums", func() {
+			BeforeEach(func() {
+				sm, err := statemate.Open[uint64](stateFile, statemate.Options{Checksums: true})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sm.Append(1, []byte{1})).To(Succeed())
+				Expect(sm.Close()).To(Succeed())
+			})
+
+			It("should use checksums when opened without the option", func() {
+				sm, err := statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				defer sm.Close()
+				Expect(sm.StorageStats().IndexSize).To(Equal(uint64(64 + 20)))
+				Expect(readAll(sm)).To(Equal(map[uint64][]byte{1: {1}}))
+			})
+		})
+
+		When("the data file is not a store", func() {
+			BeforeEach(func() {
+				err := os.WriteFile(stateFile, []byte("{}"), 0700)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should return ErrInvalidFormat without creating an index file", func() {
+				_, err := statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).To(MatchError(statemate.ErrInvalidFormat))
+				_, err = os.Stat(stateFile + ".idx")
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+
+		When("the data file does not match the index file", func() {
+			BeforeEach(func() {
+				sm, err := statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sm.Close()).To(Succeed())
+				err = os.WriteFile(stateFile, []byte("some other data"), 0700)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should return ErrInvalidFormat", func() {
+				_, err := statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).To(MatchError(statemate.ErrInvalidFormat))
+			})
+		})
+
+		When("the format version is not supported", func() {
+			BeforeEach(func() {
+				sm, err := statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sm.Close()).To(Succeed())
+
+				idx, err := os.ReadFile(stateFile + ".idx")
+				Expect(err).ToNot(HaveOccurred())
+				binary.BigEndian.PutUint16(idx[4:], 2)
+				Expect(os.WriteFile(stateFile+".idx", idx, 0700)).To(Succeed())
+			})
+
+			It("should return ErrUnsupportedFormat", func() {
+				_, err := statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).To(MatchError(statemate.ErrUnsupportedFormat))
+			})
+		})
+
+		When("the store is a legacy store without headers", func() {
+			BeforeEach(func() {
+				idx := make([]byte, 8+2*16)
+				binary.BigEndian.PutUint64(idx, 2)
+				binary.BigEndian.PutUint64(idx[8:], 1)
+				binary.BigEndian.PutUint64(idx[16:], 1)
+				binary.BigEndian.PutUint64(idx[24:], 2)
+				binary.BigEndian.PutUint64(idx[32:], 3)
+				Expect(os.WriteFile(stateFile+".idx", idx, 0700)).To(Succeed())
+				Expect(os.WriteFile(stateFile, []byte{1, 2, 2}, 0700)).To(Succeed())
+			})
+
+			It("should open and extend the store", func() {
+				sm, err := statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				defer sm.Close()
+				Expect(sm.Append(3, []byte{3})).To(Succeed())
+				Expect(readAll(sm)).To(Equal(map[uint64][]byte{1: {1}, 2: {2, 2}, 3: {3}}))
+			})
+
+			It("should not repair away entries when opened with checksums", func() {
+				sm, err := statemate.Open[uint64](stateFile, statemate.Options{Checksums: true, Repair: true})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(readAll(sm)).To(Equal(map[uint64][]byte{1: {1}, 2: {2, 2}}))
+				Expect(sm.Close()).To(Succeed())
+
+				idx, err := os.ReadFile(stateFile + ".idx")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(binary.BigEndian.Uint64(idx)).To(Equal(uint64(2)))
+			})
+
+			When("I migrate the store with checksums", func() {
+				BeforeEach(func() {
+					Expect(statemate.Migrate(stateFile, statemate.Options{Checksums: true})).To(Succeed())
+				})
+
+				It("should store checksums in the migrated store", func() {
+					sm, err := statemate.Open[uint64](stateFile, statemate.Options{})
+					Expect(err).ToNot(HaveOccurred())
+					defer sm.Close()
+					Expect(sm.StorageStats().IndexSize).To(Equal(uint64(64 + 2*20)))
+					Expect(readAll(sm)).To(Equal(map[uint64][]byte{1: {1}, 2: {2, 2}}))
+				})
+			})
+
+			When("I migrate the store", func() {
+				BeforeEach(func() {
+					Expect(statemate.Migrate(stateFile, statemate.Options{})).To(Succeed())
+				})
+
+				It("should add headers to the files", func() {
+					d, err := os.ReadFile(stateFile)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(d[:4])).To(Equal("SMDT"))
+
+					idx, err := os.ReadFile(stateFile + ".idx")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(idx[:4])).To(Equal("SMIX"))
+				})
+
+				It("should keep all entries", func() {
+					sm, err := statemate.Open[uint64](stateFile, statemate.Options{})
+					Expect(err).ToNot(HaveOccurred())
+					defer sm.Close()
+					Expect(readAll(sm)).To(Equal(map[uint64][]byte{1: {1}, 2: {2, 2}}))
+				})
+
+				It("should not leave temporary files behind", func() {
+					entries, err := os.ReadDir(tempDir)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(entries).To(HaveLen(2))
+				})
+
+				It("should leave migrated stores unchanged", func() {
+					before, err := os.ReadFile(stateFile + ".idx")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(statemate.Migrate(stateFile, statemate.Options{})).To(Succeed())
+					after, err := os.ReadFile(stateFile + ".idx")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(after).To(Equal(before))
+				})
+			})
+		})
+	})
+
+	Describe("TruncateAfter", func() {
+		var sm *statemate.StateMate[uint64]
+		var options statemate.Options
+
+		BeforeEach(func() {
+			options = statemate.Options{AllowGaps: true}
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), options)
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				err := sm.Close()
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			Expect(sm.Append(1, []byte{1})).To(Succeed())
+			Expect(sm.Append(2, []byte{2, 2})).To(Succeed())
+			Expect(sm.Append(5, []byte{5})).To(Succeed())
+			Expect(sm.Append(6, []byte{6, 6})).To(Succeed())
+		})
+
+		keys := func() []uint64 {
+			k := []uint64{}
+			for index := range sm.All() {
+				k = append(k, index)
+			}
+			return k
+		}
+
+		It("should remove all entries after the index", func() {
+			Expect(sm.TruncateAfter(2)).To(Succeed())
+			Expect(keys()).To(Equal([]uint64{1, 2}))
+			Expect(sm.GetLastIndex()).To(Equal(uint64(2)))
+			Expect(sm.StorageStats().DataSize).To(Equal(uint64(3)))
+		})
+
+		It("should remove entries after a missing index", func() {
+			Expect(sm.TruncateAfter(4)).To(Succeed())
+			Expect(keys()).To(Equal([]uint64{1, 2}))
+		})
+
+		It("should remove all entries when the index is before the first entry", func() {
+			Expect(sm.TruncateAfter(0)).To(Succeed())
+			Expect(sm.IsEmpty()).To(BeTrue())
+		})
+
+		It("should not remove entries when the index is the last index", func() {
+			Expect(sm.TruncateAfter(6)).To(Succeed())
+			Expect(keys()).To(Equal([]uint64{1, 2, 5, 6}))
+		})
+
+		It("should allow appending after the remaining entries", func() {
+			Expect(sm.TruncateAfter(2)).To(Succeed())
+			Expect(sm.Append(3, []byte{3, 3, 3})).To(Succeed())
+
+			var d []byte
+			err := sm.Read(3, func(data []byte) error {
+				d = append(d, data...)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d).To(Equal([]byte{3, 3, 3}))
+			Expect(keys()).To(Equal([]uint64{1, 2, 3}))
+		})
+
+		When("sync mode is SyncManual", func() {
+			BeforeEach(func() {
+				options.Sync = statemate.SyncManual
+			})
+
+			It("should persist the new count", func() {
+				Expect(sm.Sync()).To(Succeed())
+				Expect(sm.TruncateAfter(1)).To(Succeed())
+
+				d, err := os.ReadFile(filepath.Join(tempDir, "state.idx"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(binary.BigEndian.Uint64(d[16:])).To(Equal(uint64(1)))
+			})
+		})
+	})
+
+	Describe("PruneBefore", func() {
+		var sm *statemate.StateMate[uint64]
+		var stateFile string
+
+		BeforeEach(func() {
+			stateFile = filepath.Join(tempDir, "state")
+			var err error
+			sm, err = statemate.Open[uint64](stateFile, statemate.Options{AllowGaps: true, Checksums: true})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				err := sm.Close()
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			for i := uint64(1); i <= 100; i++ {
+				Expect(sm.Append(i, bytes.Repeat([]byte{byte(i)}, 100))).To(Succeed())
+			}
+		})
+
+		readAll := func() map[uint64][]byte {
+			m := map[uint64][]byte{}
+			err := sm.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+				d := make([]byte, len(data))
+				copy(d, data)
+				m[index] = d
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return m
+		}
+
+		When("I prune entries before an index", func() {
+			var sizeBefore int64
+			BeforeEach(func() {
+				fi, err := os.Stat(stateFile)
+				Expect(err).ToNot(HaveOccurred())
+				sizeBefore = fi.Size()
+
+				Expect(sm.PruneBefore(91)).To(Succeed())
+			})
+
+			It("should remove the entries before the index", func() {
+				Expect(sm.Count()).To(Equal(uint64(10)))
+				Expect(sm.GetFirstIndex()).To(Equal(uint64(91)))
+				Expect(sm.GetLastIndex()).To(Equal(uint64(100)))
+
+				err := sm.Read(90, func(data []byte) error {
+					return nil
+				})
+				Expect(err).To(Equal(statemate.ErrNotFound))
+			})
+
+			It("should keep the remaining entries", func() {
+				m := readAll()
+				Expect(m).To(HaveLen(10))
+				Expect(m[95]).To(Equal(bytes.Repeat([]byte{95}, 100)))
+			})
+
+			It("should reclaim the disk space", func() {
+				fi, err := os.Stat(stateFile)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fi.Size()).To(BeNumerically("<", sizeBefore))
+				Expect(fi.Size()).To(Equal(int64(16 + 10*100)))
+			})
+
+			It("should not leave temporary files behind", func() {
+				entries, err := os.ReadDir(tempDir)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(HaveLen(2))
+			})
+
+			It("should allow appending further entries", func() {
+				Expect(sm.Append(101, []byte{101})).To(Succeed())
+				Expect(readAll()).To(HaveLen(11))
+			})
+
+			It("should keep the entries after reopening", func() {
+				Expect(sm.Close()).To(Succeed())
+				var err error
+				sm, err = statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(readAll()).To(HaveLen(10))
+			})
+		})
+
+		When("I prune before the first index", func() {
+			It("should not remove any entries", func() {
+				Expect(sm.PruneBefore(1)).To(Succeed())
+				Expect(sm.Count()).To(Equal(uint64(100)))
+			})
+		})
+
+		When("I prune after the last index", func() {
+			It("should remove all entries", func() {
+				Expect(sm.PruneBefore(1000)).To(Succeed())
+				Expect(sm.IsEmpty()).To(BeTrue())
+				Expect(sm.Append(1001, []byte{1})).To(Succeed())
+				Expect(sm.GetFirstIndex()).To(Equal(uint64(1001)))
+			})
+		})
+
+		When("pruning was interrupted after the data file was replaced", func() {
+			BeforeEach(func() {
+				Expect(sm.Close()).To(Succeed())
+
+				idx, err := os.ReadFile(stateFile + ".idx")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(os.WriteFile(stateFile+".new.idx", idx, 0700)).To(Succeed())
+				Expect(os.WriteFile(stateFile+".idx", []byte("garbage"), 0700)).To(Succeed())
+
+				sm, err = statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should complete the replacement on Open", func() {
+				Expect(sm.Count()).To(Equal(uint64(100)))
+				_, err := os.Stat(stateFile + ".new.idx")
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+
+		When("pruning was interrupted before the data file was replaced", func() {
+			BeforeEach(func() {
+				Expect(sm.Close()).To(Succeed())
+
+				Expect(os.WriteFile(stateFile+".new", []byte("garbage"), 0700)).To(Succeed())
+				Expect(os.WriteFile(stateFile+".new.idx", []byte("garbage"), 0700)).To(Succeed())
+
+				var err error
+				sm, err = statemate.Open[uint64](stateFile, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should discard the replacement on Open", func() {
+				Expect(sm.Count()).To(Equal(uint64(100)))
+				entries, err := os.ReadDir(tempDir)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(HaveLen(2))
+			})
+		})
+	})
+
+	Describe("Subscribe and WaitFor", func() {
+		var sm *statemate.StateMate[uint64]
+		var closed bool
+
+		BeforeEach(func() {
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			closed = false
+			DeferCleanup(func() {
+				if !closed {
+					Expect(sm.Close()).To(Succeed())
+				}
+			})
+
+			Expect(sm.Append(1, []byte{1})).To(Succeed())
+		})
+
+		Context("WaitFor", func() {
+			It("should return immediately when the entry exists", func() {
+				Expect(sm.WaitFor(context.Background(), 1)).To(Succeed())
+			})
+
+			It("should wake up when the entry is appended", func() {
+				done := make(chan error, 1)
+				go func() {
+					done <- sm.WaitFor(context.Background(), 2)
+				}()
+
+				Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Eventually(done).Should(Receive(BeNil()))
+			})
+
+			It("should return the context error when the context is done", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				Expect(sm.WaitFor(ctx, 2)).To(MatchError(context.DeadlineExceeded))
+			})
+
+			It("should return ErrClosed when the store is closed", func() {
+				done := make(chan error, 1)
+				go func() {
+					done <- sm.WaitFor(context.Background(), 2)
+				}()
+
+				Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+				Expect(sm.Close()).To(Succeed())
+				closed = true
+				Eventually(done).Should(Receive(MatchError(statemate.ErrClosed)))
+			})
+		})
+
+		Context("Subscribe", func() {
+			It("should deliver existing and appended entries", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				s := sm.Subscribe(ctx, 0)
+				Eventually(s.C).Should(Receive(Equal(statemate.Entry[uint64]{Index: 1, Data: []byte{1}})))
+
+				Expect(sm.AppendBatch([]statemate.Entry[uint64]{
+					{Index: 2, Data: []byte{2}},
+					{Index: 3, Data: []byte{3}},
+				})).To(Succeed())
+				Eventually(s.C).Should(Receive(Equal(statemate.Entry[uint64]{Index: 2, Data: []byte{2}})))
+				Eventually(s.C).Should(Receive(Equal(statemate.Entry[uint64]{Index: 3, Data: []byte{3}})))
+				Expect(s.Err()).ToNot(HaveOccurred())
+			})
+
+			It("should skip entries before from", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				s := sm.Subscribe(ctx, 2)
+				Consistently(s.C, 50*time.Millisecond).ShouldNot(Receive())
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Eventually(s.C).Should(Receive(Equal(statemate.Entry[uint64]{Index: 2, Data: []byte{2}})))
+			})
+
+			It("should end when the context is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				s := sm.Subscribe(ctx, 2)
+				cancel()
+				Eventually(s.C).Should(BeClosed())
+				Expect(s.Err()).To(MatchError(context.Canceled))
+			})
+
+			It("should end when the store is closed", func() {
+				s := sm.Subscribe(context.Background(), 2)
+				Expect(sm.Close()).To(Succeed())
+				closed = true
+				Eventually(s.C).Should(BeClosed())
+				Expect(s.Err()).To(MatchError(statemate.ErrClosed))
+			})
+
+			It("should end with ErrTruncated when delivered entries are removed", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				s := sm.Subscribe(ctx, 0)
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Eventually(s.C).Should(Receive(Equal(statemate.Entry[uint64]{Index: 1, Data: []byte{1}})))
+				Eventually(s.C).Should(Receive(Equal(statemate.Entry[uint64]{Index: 2, Data: []byte{2}})))
+
+				Expect(sm.TruncateAfter(1)).To(Succeed())
+				Eventually(s.C).Should(BeClosed())
+				Expect(s.Err()).To(MatchError(statemate.ErrTruncated))
+			})
+
+			It("should continue when only entries not delivered yet are removed", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				s := sm.Subscribe(ctx, 3)
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Expect(sm.TruncateAfter(1)).To(Succeed())
+				Expect(sm.Append(2, []byte{4})).To(Succeed())
+				Expect(sm.Append(3, []byte{3})).To(Succeed())
+				Eventually(s.C).Should(Receive(Equal(statemate.Entry[uint64]{Index: 3, Data: []byte{3}})))
+				Expect(s.Err()).ToNot(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("OpenFollower", func() {
+		var writer *statemate.StateMate[uint64]
+		var follower *statemate.StateMate[uint64]
+		var fileName string
+
+		BeforeEach(func() {
+			fileName = filepath.Join(tempDir, "state")
+
+			var err error
+			writer, err = statemate.Open[uint64](fileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(writer.Close()).To(Succeed())
+			})
+
+			Expect(writer.Append(1, []byte{1})).To(Succeed())
+
+			follower, err = statemate.OpenFollower[uint64](fileName, statemate.FollowerOptions{PollInterval: 5 * time.Millisecond})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(follower.Close()).To(Succeed())
+			})
+		})
+
+		It("should read the existing entries", func() {
+			Expect(follower.Count()).To(Equal(uint64(1)))
+			Expect(follower.Read(1, func(data []byte) error {
+				Expect(data).To(Equal([]byte{1}))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should see entries appended by the writer", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			for i := uint64(2); i <= 1000; i++ {
+				Expect(writer.Append(i, bytes.Repeat([]byte{byte(i)}, 100))).To(Succeed())
+			}
+
+			Expect(follower.WaitFor(ctx, 1000)).To(Succeed())
+			Expect(follower.Count()).To(Equal(uint64(1000)))
+			Expect(follower.Read(1000, func(data []byte) error {
+				Expect(data).To(Equal(bytes.Repeat([]byte{byte(1000 % 256)}, 100)))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should deliver appended entries to subscribers", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			s := follower.Subscribe(ctx, 2)
+			Expect(writer.Append(2, []byte{2})).To(Succeed())
+			Eventually(s.C).Should(Receive(Equal(statemate.Entry[uint64]{Index: 2, Data: []byte{2}})))
+		})
+
+		It("should end subscriptions when delivered entries are rewritten between polls", func() {
+			Expect(writer.Append(2, []byte{2})).To(Succeed())
+
+			slow, err := statemate.OpenFollower[uint64](fileName, statemate.FollowerOptions{PollInterval: 200 * time.Millisecond})
+			Expect(err).ToNot(HaveOccurred())
+			defer slow.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			s := slow.Subscribe(ctx, 1)
+			Eventually(s.C).Should(Receive(Equal(statemate.Entry[uint64]{Index: 1, Data: []byte{1}})))
+			Eventually(s.C).Should(Receive(Equal(statemate.Entry[uint64]{Index: 2, Data: []byte{2}})))
+
+			// the count grows from 2 to 3, so only the recorded truncation reveals the rewritten entry
+			Expect(writer.TruncateAfter(1)).To(Succeed())
+			Expect(writer.Append(2, []byte{9})).To(Succeed())
+			Expect(writer.Append(3, []byte{3})).To(Succeed())
+
+			Eventually(s.C, time.Second).Should(BeClosed())
+			Expect(s.Err()).To(MatchError(statemate.ErrTruncated))
+		})
+
+		It("should follow the store after it has been pruned", func() {
+			Expect(writer.Append(2, []byte{2})).To(Succeed())
+			Expect(writer.Append(3, []byte{3})).To(Succeed())
+			Expect(writer.PruneBefore(2)).To(Succeed())
+			Expect(writer.Append(4, []byte{4})).To(Succeed())
+
+			Eventually(follower.GetFirstIndex).Should(Equal(uint64(2)))
+			Eventually(follower.GetLastIndex).Should(Equal(uint64(4)))
+			Expect(follower.Read(4, func(data []byte) error {
+				Expect(data).To(Equal([]byte{4}))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should not allow modifications", func() {
+			Expect(follower.Append(2, []byte{2})).To(MatchError(statemate.ErrReadOnly))
+			Expect(follower.Truncate()).To(MatchError(statemate.ErrReadOnly))
+			Expect(follower.TruncateAfter(0)).To(MatchError(statemate.ErrReadOnly))
+			Expect(follower.PruneBefore(1)).To(MatchError(statemate.ErrReadOnly))
+			Expect(follower.Sync()).To(Succeed())
+		})
+
+		It("should not create missing stores", func() {
+			_, err := statemate.OpenFollower[uint64](filepath.Join(tempDir, "missing"), statemate.FollowerOptions{})
+			Expect(err).To(MatchError(os.ErrNotExist))
+			_, err = os.Stat(filepath.Join(tempDir, "missing"))
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+	})
+
+	Describe("locking", func() {
+		var fileName string
+		var sm *statemate.StateMate[uint64]
+
+		BeforeEach(func() {
+			fileName = filepath.Join(tempDir, "state")
+
+			var err error
+			sm, err = statemate.Open[uint64](fileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sm.Append(1, []byte{1})).To(Succeed())
+		})
+
+		Context("when the store is open", func() {
+			AfterEach(func() {
+				Expect(sm.Close()).To(Succeed())
+			})
+
+			It("should not open it again", func() {
+				_, err := statemate.Open[uint64](fileName, statemate.Options{})
+				Expect(err).To(MatchError(statemate.ErrLocked))
+			})
+
+			It("should give up after the lock timeout", func() {
+				start := time.Now()
+				_, err := statemate.Open[uint64](fileName, statemate.Options{LockTimeout: 50 * time.Millisecond})
+				Expect(err).To(MatchError(statemate.ErrLocked))
+				Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+			})
+
+			It("should keep the store locked after it has been pruned", func() {
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Expect(sm.PruneBefore(2)).To(Succeed())
+
+				_, err := statemate.Open[uint64](fileName, statemate.Options{})
+				Expect(err).To(MatchError(statemate.ErrLocked))
+			})
+
+			It("should allow followers", func() {
+				follower, err := statemate.OpenFollower[uint64](fileName, statemate.FollowerOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(follower.Close()).To(Succeed())
+			})
+		})
+
+		It("should open the store after it has been closed", func() {
+			Expect(sm.Close()).To(Succeed())
+
+			reopened, err := statemate.Open[uint64](fileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reopened.Close()).To(Succeed())
+		})
+
+		It("should wait for the lock to be released", func() {
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(20 * time.Millisecond)
+				Expect(sm.Close()).To(Succeed())
+			}()
+
+			reopened, err := statemate.Open[uint64](fileName, statemate.Options{LockTimeout: 5 * time.Second})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reopened.Count()).To(Equal(uint64(1)))
+			Expect(reopened.Close()).To(Succeed())
+		})
+
+		It("should wait for a store being pruned", func() {
+			Expect(sm.Append(2, []byte{2})).To(Succeed())
+
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(20 * time.Millisecond)
+				Expect(sm.PruneBefore(2)).To(Succeed())
+				Expect(sm.Append(3, []byte{3})).To(Succeed())
+				Expect(sm.Close()).To(Succeed())
+			}()
+
+			reopened, err := statemate.Open[uint64](fileName, statemate.Options{LockTimeout: 5 * time.Second})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reopened.GetFirstIndex()).To(Equal(uint64(2)))
+			Expect(reopened.GetLastIndex()).To(Equal(uint64(3)))
+			Expect(reopened.Close()).To(Succeed())
+		})
+	})
+
+	Describe("OpenReadOnly", func() {
+		var fileName string
+
+		BeforeEach(func() {
+			fileName = filepath.Join(tempDir, "state")
+		})
+
+		It("should not create missing stores", func() {
+			_, err := statemate.OpenReadOnly[uint64](fileName, statemate.Options{})
+			Expect(err).To(MatchError(os.ErrNotExist))
+			_, err = os.Stat(fileName)
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+
+		It("should be used by Open with the ReadOnly option", func() {
+			_, err := statemate.Open[uint64](fileName, statemate.Options{ReadOnly: true})
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+
+		Context("when the store exists", func() {
+			BeforeEach(func() {
+				sm, err := statemate.Open[uint64](fileName, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sm.Append(1, []byte{1})).To(Succeed())
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Expect(sm.Close()).To(Succeed())
+			})
+
+			It("should read the entries", func() {
+				sm, err := statemate.OpenReadOnly[uint64](fileName, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				defer sm.Close()
+
+				Expect(sm.Count()).To(Equal(uint64(2)))
+				Expect(sm.Read(2, func(data []byte) error {
+					Expect(data).To(Equal([]byte{2}))
+					return nil
+				})).To(Succeed())
+			})
+
+			It("should not modify the files", func() {
+				dataInfo, err := os.Stat(fileName)
+				Expect(err).ToNot(HaveOccurred())
+
+				sm, err := statemate.OpenReadOnly[uint64](fileName, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(sm.Append(3, []byte{3})).To(MatchError(statemate.ErrReadOnly))
+				Expect(sm.AppendBatch([]statemate.Entry[uint64]{{Index: 3, Data: []byte{3}}})).To(MatchError(statemate.ErrReadOnly))
+				Expect(sm.Truncate()).To(MatchError(statemate.ErrReadOnly))
+				Expect(sm.TruncateAfter(1)).To(MatchError(statemate.ErrReadOnly))
+				Expect(sm.PruneBefore(2)).To(MatchError(statemate.ErrReadOnly))
+				Expect(sm.Close()).To(Succeed())
+
+				afterInfo, err := os.Stat(fileName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(afterInfo.Size()).To(Equal(dataInfo.Size()))
+				Expect(afterInfo.ModTime()).To(Equal(dataInfo.ModTime()))
+			})
+
+			It("should open files without write permission", func() {
+				Expect(os.Chmod(fileName, 0400)).To(Succeed())
+				Expect(os.Chmod(fileName+".idx", 0400)).To(Succeed())
+
+				sm, err := statemate.OpenReadOnly[uint64](fileName, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sm.Count()).To(Equal(uint64(2)))
+				Expect(sm.Close()).To(Succeed())
+			})
+
+			It("should share the store with other readers but not with writers", func() {
+				sm, err := statemate.OpenReadOnly[uint64](fileName, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				defer sm.Close()
+
+				other, err := statemate.OpenReadOnly[uint64](fileName, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(other.Close()).To(Succeed())
+
+				_, err = statemate.Open[uint64](fileName, statemate.Options{})
+				Expect(err).To(MatchError(statemate.ErrLocked))
+			})
+
+			It("should not be opened while a writer has the store open", func() {
+				sm, err := statemate.Open[uint64](fileName, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				defer sm.Close()
+
+				_, err = statemate.OpenReadOnly[uint64](fileName, statemate.Options{})
+				Expect(err).To(MatchError(statemate.ErrLocked))
+			})
+		})
+	})
+
+	Describe("conditional appends", func() {
+		var sm *statemate.StateMate[uint64]
+
+		BeforeEach(func() {
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), statemate.Options{AllowGaps: true})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(sm.Close()).To(Succeed())
+			})
+		})
+
+		Context("AppendNext", func() {
+			It("should start an empty store with index 0", func() {
+				index, err := sm.AppendNext([]byte{0})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(index).To(Equal(uint64(0)))
+			})
+
+			It("should append after the last index", func() {
+				Expect(sm.Append(5, []byte{5})).To(Succeed())
+
+				index, err := sm.AppendNext([]byte{6})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(index).To(Equal(uint64(6)))
+				Expect(sm.Read(6, func(data []byte) error {
+					Expect(data).To(Equal([]byte{6}))
+					return nil
+				})).To(Succeed())
+			})
+
+			It("should assign unique indices to concurrent appends", func() {
+				indices := make(chan uint64, 100)
+				done := make(chan struct{})
+				for i := 0; i < 10; i++ {
+					go func() {
+						defer GinkgoRecover()
+						defer func() { done <- struct{}{} }()
+						for j := 0; j < 10; j++ {
+							index, err := sm.AppendNext([]byte{1})
+							Expect(err).ToNot(HaveOccurred())
+							indices <- index
+						}
+					}()
+				}
+
+				for i := 0; i < 10; i++ {
+					<-done
+				}
+				close(indices)
+
+				seen := map[uint64]bool{}
+				for index := range indices {
+					seen[index] = true
+				}
+				Expect(seen).To(HaveLen(100))
+				Expect(sm.Count()).To(Equal(uint64(100)))
+				Expect(sm.GetLastIndex()).To(Equal(uint64(99)))
+			})
+		})
+
+		Context("AppendIfLast", func() {
+			It("should append to an empty store when math.MaxUint64 is expected", func() {
+				Expect(sm.AppendIfLast(math.MaxUint64, 1, []byte{1})).To(Succeed())
+				Expect(sm.GetLastIndex()).To(Equal(uint64(1)))
+			})
+
+			It("should append when the last index matches", func() {
+				Expect(sm.Append(1, []byte{1})).To(Succeed())
+				Expect(sm.AppendIfLast(1, 3, []byte{3})).To(Succeed())
+				Expect(sm.GetLastIndex()).To(Equal(uint64(3)))
+			})
+
+			It("should not append when the last index does not match", func() {
+				Expect(sm.Append(1, []byte{1})).To(Succeed())
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Expect(sm.AppendIfLast(1, 3, []byte{3})).To(MatchError(statemate.ErrLastIndexMismatch))
+				Expect(sm.Count()).To(Equal(uint64(2)))
+			})
+
+			It("should validate the index", func() {
+				Expect(sm.Append(2, []byte{2})).To(Succeed())
+				Expect(sm.AppendIfLast(2, 2, []byte{2})).To(MatchError(statemate.ErrIndexMustBeIncreasing))
+			})
+		})
+	})
+
+	Describe("transactions", func() {
+		var sm *statemate.StateMate[uint64]
+		var tx *statemate.Tx[uint64]
+
+		headerCount := func() uint64 {
+			d, err := os.ReadFile(filepath.Join(tempDir, "state.idx"))
+			Expect(err).ToNot(HaveOccurred())
+			return binary.BigEndian.Uint64(d[16:])
+		}
+
+		BeforeEach(func() {
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(sm.Close()).To(Succeed())
+			})
+
+			Expect(sm.Append(1, []byte{1})).To(Succeed())
+
+			tx, err = sm.Begin()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tx.Append(2, []byte{2})).To(Succeed())
+			Expect(tx.Append(3, []byte{3, 3})).To(Succeed())
+		})
+
+		It("should not make staged entries visible", func() {
+			Expect(sm.Count()).To(Equal(uint64(1)))
+			Expect(sm.Read(2, func([]byte) error { return nil })).To(MatchError(statemate.ErrNotFound))
+			Expect(headerCount()).To(Equal(uint64(1)))
+			Expect(tx.Rollback()).To(Succeed())
+		})
+
+		It("should validate entries against the staged ones", func() {
+			Expect(tx.Append(3, []byte{3})).To(MatchError(statemate.ErrIndexMustBeIncreasing))
+			Expect(tx.Append(5, []byte{5})).To(MatchError(statemate.ErrIndexGapsAreNotAllowed))
+			Expect(tx.Append(4, []byte{4})).To(Succeed())
+			Expect(tx.Commit()).To(Succeed())
+			Expect(sm.GetLastIndex()).To(Equal(uint64(4)))
+		})
+
+		Context("when the transaction is committed", func() {
+			BeforeEach(func() {
+				Expect(tx.Commit()).To(Succeed())
+			})
+
+			It("should make all entries visible", func() {
+				Expect(sm.Count()).To(Equal(uint64(3)))
+				Expect(headerCount()).To(Equal(uint64(3)))
+				Expect(sm.Read(3, func(data []byte) error {
+					Expect(data).To(Equal([]byte{3, 3}))
+					return nil
+				})).To(Succeed())
+			})
+
+			It("should not be usable anymore", func() {
+				Expect(tx.Append(4, []byte{4})).To(MatchError(statemate.ErrTxDone))
+				Expect(tx.Commit()).To(MatchError(statemate.ErrTxDone))
+				Expect(tx.Rollback()).To(MatchError(statemate.ErrTxDone))
+			})
+		})
+
+		Context("when the transaction is rolled back", func() {
+			BeforeEach(func() {
+				Expect(tx.Rollback()).To(Succeed())
+			})
+
+			It("should discard the staged entries", func() {
+				Expect(sm.Count()).To(Equal(uint64(1)))
+				Expect(sm.StorageStats().DataSize).To(Equal(uint64(1)))
+			})
+
+			It("should reuse the space of the staged entries", func() {
+				Expect(sm.Append(2, []byte{4})).To(Succeed())
+				Expect(sm.Read(2, func(data []byte) error {
+					Expect(data).To(Equal([]byte{4}))
+					return nil
+				})).To(Succeed())
+			})
+		})
+
+		It("should block other writers until the transaction is finished", func() {
+			appended := make(chan error, 1)
+			go func() {
+				appended <- sm.Append(4, []byte{4})
+			}()
+
+			Consistently(appended, 50*time.Millisecond).ShouldNot(Receive())
+			Expect(sm.Count()).To(Equal(uint64(1)))
+			Expect(tx.Commit()).To(Succeed())
+			Eventually(appended).Should(Receive(BeNil()))
+			Expect(sm.Count()).To(Equal(uint64(4)))
+		})
+	})
+
+	Describe("Snapshot", func() {
+		var sm *statemate.StateMate[uint64]
+		var snapshot *statemate.Snapshot[uint64]
+		var fileName string
+
+		BeforeEach(func() {
+			fileName = filepath.Join(tempDir, "state")
+
+			var err error
+			sm, err = statemate.Open[uint64](fileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				err := sm.Close()
+				if err != nil {
+					Expect(err).To(MatchError(statemate.ErrClosed))
+				}
+			})
+
+			Expect(sm.Append(1, []byte{1})).To(Succeed())
+			Expect(sm.Append(2, []byte{2})).To(Succeed())
+
+			snapshot, err = sm.Snapshot()
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(snapshot.Release()).To(Succeed())
+			})
+		})
+
+		keys := func(seq iter.Seq2[uint64, []byte]) []uint64 {
+			k := []uint64{}
+			for index := range seq {
+				k = append(k, index)
+			}
+			return k
+		}
+
+		It("should contain the entries at the time it was taken", func() {
+			Expect(snapshot.Count()).To(Equal(uint64(2)))
+			Expect(snapshot.IsEmpty()).To(BeFalse())
+			Expect(snapshot.GetFirstIndex()).To(Equal(uint64(1)))
+			Expect(snapshot.GetLastIndex()).To(Equal(uint64(2)))
+			Expect(keys(snapshot.All())).To(Equal([]uint64{1, 2}))
+		})
+
+		It("should not see appended entries", func() {
+			for i := uint64(3); i < 10000; i++ {
+				Expect(sm.Append(i, bytes.Repeat([]byte{byte(i)}, 100))).To(Succeed())
+			}
+
+			Expect(keys(snapshot.Backward())).To(Equal([]uint64{2, 1}))
+			Expect(snapshot.Read(3, func([]byte) error { return nil })).To(MatchError(statemate.ErrNotFound))
+			Expect(snapshot.Read(2, func(data []byte) error {
+				Expect(data).To(Equal([]byte{2}))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should allow appending while iterating", func() {
+			next := uint64(3)
+			for index, data := range snapshot.All() {
+				Expect(data).To(Equal([]byte{byte(index)}))
+				Expect(sm.Append(next, []byte{byte(next)})).To(Succeed())
+				next++
+			}
+
+			Expect(sm.GetLastIndex()).To(Equal(uint64(4)))
+		})
+
+		It("should stay readable when the store is pruned", func() {
+			Expect(sm.Append(3, []byte{3})).To(Succeed())
+			Expect(sm.PruneBefore(3)).To(Succeed())
+
+			Expect(keys(snapshot.Between(0, 10))).To(Equal([]uint64{1, 2}))
+
+			indices := []uint64{}
+			for e, err := range snapshot.Entries(0, 10) {
+				Expect(err).ToNot(HaveOccurred())
+				indices = append(indices, e.Index)
+			}
+			Expect(indices).To(Equal([]uint64{1, 2}))
+		})
+
+		It("should stay readable when the store is closed", func() {
+			Expect(sm.Close()).To(Succeed())
+
+			Expect(snapshot.Range(1, 2, func(index uint64, data []byte) error {
+				Expect(data).To(Equal([]byte{byte(index)}))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should prevent truncating entries until it is released", func() {
+			Expect(sm.TruncateAfter(1)).To(MatchError(statemate.ErrSnapshotsOpen))
+			Expect(snapshot.Release()).To(Succeed())
+			Expect(sm.TruncateAfter(1)).To(Succeed())
+		})
+	})
+
+	Describe("Backup", func() {
+		var sm *statemate.StateMate[uint64]
+		var backupFileName string
+
+		BeforeEach(func() {
+			backupFileName = filepath.Join(tempDir, "backup")
+
+			var err error
+			sm, err = statemate.Open[uint64](filepath.Join(tempDir, "state"), statemate.Options{Checksums: true})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(sm.Close()).To(Succeed())
+			})
+
+			for i := uint64(1); i <= 100; i++ {
+				Expect(sm.Append(i, []byte{byte(i), byte(i)})).To(Succeed())
+			}
+		})
+
+		It("should copy all entries into truncated files", func() {
+			Expect(sm.Backup(context.Background(), backupFileName)).To(Succeed())
+
+			backup, err := statemate.Open[uint64](backupFileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			defer backup.Close()
+
+			Expect(backup.Count()).To(Equal(uint64(100)))
+			Expect(backup.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+				Expect(data).To(Equal([]byte{byte(index), byte(index)}))
+				return nil
+			})).To(Succeed())
+
+			stats := backup.StorageStats()
+			Expect(stats.DataFileSize).To(Equal(stats.DataSize + 16))
+			Expect(stats.IndexFileSize).To(Equal(stats.IndexSize))
+		})
+
+		It("should copy a consistent prefix while entries are appended", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				for i := uint64(101); i <= 2000; i++ {
+					Expect(sm.Append(i, []byte{byte(i), byte(i)})).To(Succeed())
+				}
+			}()
+
+			Expect(sm.Backup(context.Background(), backupFileName)).To(Succeed())
+			<-done
+
+			backup, err := statemate.Open[uint64](backupFileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			defer backup.Close()
+
+			Expect(backup.GetFirstIndex()).To(Equal(uint64(1)))
+			Expect(backup.Count()).To(Equal(backup.GetLastIndex()))
+			Expect(backup.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+				Expect(data).To(Equal([]byte{byte(index), byte(index)}))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should not overwrite existing files", func() {
+			Expect(os.WriteFile(backupFileName, []byte("keep"), 0600)).To(Succeed())
+			Expect(sm.Backup(context.Background(), backupFileName)).To(MatchError(os.ErrExist))
+
+			d, err := os.ReadFile(backupFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(d).To(Equal([]byte("keep")))
+		})
+
+		It("should not leave files behind when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			Expect(sm.Backup(ctx, backupFileName)).To(MatchError(context.Canceled))
+
+			entries, err := os.ReadDir(tempDir)
+			Expect(err).ToNot(HaveOccurred())
+			names := []string{}
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			Expect(names).To(ConsistOf("state", "state.idx"))
+		})
+	})
+
+	Describe("CopyTo", func() {
+		var src *statemate.StateMate[uint64]
+		var dst *statemate.StateMate[uint64]
+
+		BeforeEach(func() {
+			var err error
+			src, err = statemate.Open[uint64](filepath.Join(tempDir, "src"), statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(src.Close()).To(Succeed())
+			})
+
+			dst, err = statemate.Open[uint64](filepath.Join(tempDir, "dst"), statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(dst.Close()).To(Succeed())
+			})
+
+			for i := uint64(1); i <= 10; i++ {
+				Expect(src.Append(i, []byte{byte(i)})).To(Succeed())
+			}
+		})
+
+		It("should copy all entries into an empty store", func() {
+			copied, err := src.CopyTo(context.Background(), dst, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(copied).To(Equal(uint64(10)))
+			Expect(dst.GetFirstIndex()).To(Equal(uint64(1)))
+			Expect(dst.GetLastIndex()).To(Equal(uint64(10)))
+		})
+
+		It("should start an empty store at from", func() {
+			copied, err := src.CopyTo(context.Background(), dst, 5)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(copied).To(Equal(uint64(6)))
+			Expect(dst.GetFirstIndex()).To(Equal(uint64(5)))
+		})
+
+		Context("when the destination has a prefix of the entries", func() {
+			BeforeEach(func() {
+				for i := uint64(1); i <= 4; i++ {
+					Expect(dst.Append(i, []byte{byte(i)})).To(Succeed())
+				}
+			})
+
+			It("should only copy the following entries", func() {
+				copied, err := src.CopyTo(context.Background(), dst, 0)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(copied).To(Equal(uint64(6)))
+				Expect(dst.Count()).To(Equal(uint64(10)))
+				Expect(dst.Read(10, func(data []byte) error {
+					Expect(data).To(Equal([]byte{10}))
+					return nil
+				})).To(Succeed())
+			})
+
+			It("should not copy anything when the destination is up to date", func() {
+				_, err := src.CopyTo(context.Background(), dst, 0)
+				Expect(err).ToNot(HaveOccurred())
+
+				copied, err := src.CopyTo(context.Background(), dst, 0)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(copied).To(BeZero())
+			})
+		})
+
+		It("should detect a diverged last entry", func() {
+			Expect(dst.Append(1, []byte{1})).To(Succeed())
+			Expect(dst.Append(2, []byte{42})).To(Succeed())
+
+			_, err := src.CopyTo(context.Background(), dst, 0)
+			Expect(err).To(MatchError(statemate.ErrDiverged))
+			Expect(dst.Count()).To(Equal(uint64(2)))
+		})
+
+		It("should detect a last entry missing in the source", func() {
+			Expect(dst.Append(11, []byte{11})).To(Succeed())
+
+			_, err := src.CopyTo(context.Background(), dst, 0)
+			Expect(err).To(MatchError(statemate.ErrDiverged))
+		})
+	})
+
+	Describe("compression", func() {
+		var fileName string
+
+		BeforeEach(func() {
+			fileName = filepath.Join(tempDir, "state")
+		})
+
+		payload := func(index uint64) []byte {
+			return bytes.Repeat([]byte(fmt.Sprintf(`{"index":%d}`, index)), 100)
+		}
+
+		for _, codec := range []statemate.Codec{statemate.Gzip, statemate.Zstd, statemate.Snappy} {
+			Context(fmt.Sprintf("with codec %d", codec.ID()), func() {
+				BeforeEach(func() {
+					sm, err := statemate.Open[uint64](fileName, statemate.Options{Codec: codec, Checksums: true})
+					Expect(err).ToNot(HaveOccurred())
+					for i := uint64(1); i <= 10; i++ {
+						Expect(sm.Append(i, payload(i))).To(Succeed())
+					}
+					Expect(sm.Close()).To(Succeed())
+				})
+
+				It("should store the compressed data", func() {
+					sm, err := statemate.Open[uint64](fileName, statemate.Options{})
+					Expect(err).ToNot(HaveOccurred())
+					defer sm.Close()
+
+					Expect(sm.StorageStats().DataSize).To(BeNumerically("<", 10*len(payload(10))/5))
+				})
+
+				It("should decompress the entries without setting the codec", func() {
+					sm, err := statemate.Open[uint64](fileName, statemate.Options{})
+					Expect(err).ToNot(HaveOccurred())
+					defer sm.Close()
+
+					Expect(sm.Read(3, func(data []byte) error {
+						Expect(data).To(Equal(payload(3)))
+						return nil
+					})).To(Succeed())
+
+					Expect(sm.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+						Expect(data).To(Equal(payload(index)))
+						return nil
+					})).To(Succeed())
+
+					for index, data := range sm.Backward() {
+						Expect(data).To(Equal(payload(index)))
+					}
+				})
+			})
+		}
+
+		Context("with a custom codec", func() {
+			BeforeEach(func() {
+				sm, err := statemate.Open[uint64](fileName, statemate.Options{Codec: reverseCodec{}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sm.Append(1, []byte{1, 2, 3})).To(Succeed())
+				Expect(sm.Close()).To(Succeed())
+			})
+
+			It("should use the codec", func() {
+				sm, err := statemate.Open[uint64](fileName, statemate.Options{Codec: reverseCodec{}})
+				Expect(err).ToNot(HaveOccurred())
+				defer sm.Close()
+
+				Expect(sm.Read(1, func(data []byte) error {
+					Expect(data).To(Equal([]byte{1, 2, 3}))
+					return nil
+				})).To(Succeed())
+
+				d, err := os.ReadFile(fileName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(d[16:19]).To(Equal([]byte{3, 2, 1}))
+			})
+
+			It("should not open the store without the codec", func() {
+				_, err := statemate.Open[uint64](fileName, statemate.Options{})
+				Expect(err).To(MatchError(statemate.ErrUnsupportedFormat))
+			})
+		})
+
+		It("should ignore the codec option for existing stores", func() {
+			sm, err := statemate.Open[uint64](fileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sm.Close()).To(Succeed())
+
+			sm, err = statemate.Open[uint64](fileName, statemate.Options{Codec: statemate.Zstd})
+			Expect(err).ToNot(HaveOccurred())
+			defer sm.Close()
+
+			Expect(sm.Append(1, []byte{1})).To(Succeed())
+			Expect(sm.StorageStats().DataSize).To(Equal(uint64(1)))
+		})
+	})
+
+	Describe("dictionary compression", func() {
+		var fileName string
+		var sm *statemate.StateMate[uint64]
+
+		record := func(index uint64) []byte {
+			return []byte(fmt.Sprintf(`{"type":"account_updated","account_id":%d,"status":"active","region":"eu-central-1"}`, index))
+		}
+
+		readAll := func(sm *statemate.StateMate[uint64]) {
+			Expect(sm.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+				Expect(data).To(Equal(record(index)))
+				return nil
+			})).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			fileName = filepath.Join(tempDir, "state")
+
+			var err error
+			sm, err = statemate.Open[uint64](fileName, statemate.Options{
+				Codec:             statemate.ZstdDictionary,
+				DictionaryEntries: 500,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				err := sm.Close()
+				if err != nil {
+					Expect(err).To(MatchError(statemate.ErrClosed))
+				}
+			})
+
+			for i := uint64(1); i <= 1000; i++ {
+				Expect(sm.Append(i, record(i))).To(Succeed())
+			}
+		})
+
+		It("should train the dictionary after the configured number of entries", func() {
+			_, err := os.Stat(fileName + ".dict")
+			Expect(err).ToNot(HaveOccurred())
+			readAll(sm)
+		})
+
+		It("should compress entries appended after training better", func() {
+			before := sm.StorageStats().DataSize
+			for i := uint64(1001); i <= 1500; i++ {
+				Expect(sm.Append(i, record(i))).To(Succeed())
+			}
+			withDictionary := sm.StorageStats().DataSize - before
+
+			plain, err := statemate.Open[uint64](filepath.Join(tempDir, "plain"), statemate.Options{Codec: statemate.Zstd})
+			Expect(err).ToNot(HaveOccurred())
+			defer plain.Close()
+			for i := uint64(1001); i <= 1500; i++ {
+				Expect(plain.Append(i, record(i))).To(Succeed())
+			}
+
+			Expect(withDictionary).To(BeNumerically("<", plain.StorageStats().DataSize/2))
+		})
+
+		It("should use the dictionary after reopening", func() {
+			Expect(sm.Close()).To(Succeed())
+
+			reopened, err := statemate.OpenReadOnly[uint64](fileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			defer reopened.Close()
+
+			readAll(reopened)
+		})
+
+		It("should only be trained once", func() {
+			Expect(sm.TrainDictionary(100)).To(MatchError(statemate.ErrDictionaryExists))
+		})
+
+		It("should copy the dictionary into backups", func() {
+			Expect(sm.Backup(context.Background(), filepath.Join(tempDir, "backup"))).To(Succeed())
+
+			backup, err := statemate.Open[uint64](filepath.Join(tempDir, "backup"), statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			defer backup.Close()
+
+			readAll(backup)
+		})
+
+		Context("when the dictionary is trained on demand", func() {
+			var follower *statemate.StateMate[uint64]
+
+			BeforeEach(func() {
+				Expect(sm.Close()).To(Succeed())
+				Expect(os.Remove(fileName + ".dict")).To(Succeed())
+				Expect(os.Remove(fileName)).To(Succeed())
+				Expect(os.Remove(fileName + ".idx")).To(Succeed())
+
+				var err error
+				sm, err = statemate.Open[uint64](fileName, statemate.Options{Codec: statemate.ZstdDictionary})
+				Expect(err).ToNot(HaveOccurred())
+				for i := uint64(1); i <= 100; i++ {
+					Expect(sm.Append(i, record(i))).To(Succeed())
+				}
+
+				follower, err = statemate.OpenFollower[uint64](fileName, statemate.FollowerOptions{PollInterval: 5 * time.Millisecond})
+				Expect(err).ToNot(HaveOccurred())
+				DeferCleanup(func() {
+					Expect(follower.Close()).To(Succeed())
+				})
+			})
+
+			It("should be picked up by followers", func() {
+				Expect(sm.TrainDictionary(100)).To(Succeed())
+				Expect(sm.Append(101, record(101))).To(Succeed())
+
+				Eventually(follower.GetLastIndex).Should(Equal(uint64(101)))
+				readAll(follower)
+			})
+		})
+
+		It("should keep appending and retry training when the entries are too small", func() {
+			tiny, err := statemate.Open[uint64](filepath.Join(tempDir, "tiny"), statemate.Options{
+				Codec:             statemate.ZstdDictionary,
+				DictionaryEntries: 4,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer tiny.Close()
+
+			for i := uint64(0); i < 8; i++ {
+				Expect(tiny.Append(i, []byte{1, 2, byte(i)})).To(Succeed())
+			}
+			Expect(tiny.DictionaryError()).To(HaveOccurred())
+			Expect(filepath.Join(tempDir, "tiny.dict")).ToNot(BeAnExistingFile())
+
+			for i := uint64(8); i <= 1000; i++ {
+				Expect(tiny.Append(i, record(i))).To(Succeed())
+			}
+			Expect(tiny.DictionaryError()).ToNot(HaveOccurred())
+			Expect(filepath.Join(tempDir, "tiny.dict")).To(BeAnExistingFile())
+
+			Expect(tiny.Read(1000, func(data []byte) error {
+				Expect(data).To(Equal(record(1000)))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should not train dictionaries of stores without dictionary compression", func() {
+			plain, err := statemate.Open[uint64](filepath.Join(tempDir, "plain"), statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			defer plain.Close()
+
+			Expect(plain.TrainDictionary(100)).To(MatchError(statemate.ErrNoDictionaryCompression))
+		})
+	})
+
+	Describe("Pack", func() {
+		var fileName string
+		var packedFileName string
+
+		payload := func(index uint64) []byte {
+			return bytes.Repeat([]byte(fmt.Sprintf(`{"index":%d}`, index)), int(index%7))
+		}
+
+		BeforeEach(func() {
+			fileName = filepath.Join(tempDir, "state")
+			packedFileName = filepath.Join(tempDir, "state.packed")
+
+			sm, err := statemate.Open[uint64](fileName, statemate.Options{AllowGaps: true, Checksums: true})
+			Expect(err).ToNot(HaveOccurred())
+			for i := uint64(2); i <= 2000; i += 2 {
+				Expect(sm.Append(i, payload(i))).To(Succeed())
+			}
+			Expect(sm.Truncate()).To(Succeed())
+			Expect(sm.Close()).To(Succeed())
+		})
+
+		openPacked := func(options statemate.Options) *statemate.Packed[uint64] {
+			p, err := statemate.OpenPacked[uint64](packedFileName, options)
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(func() {
+				Expect(p.Close()).To(Succeed())
+			})
+			return p
+		}
+
+		It("should read every entry by index", func() {
+			Expect(statemate.Pack(fileName, packedFileName, statemate.PackOptions{BlockSize: 1024})).To(Succeed())
+
+			p := openPacked(statemate.Options{})
+			Expect(p.Count()).To(Equal(uint64(1000)))
+			Expect(p.IsEmpty()).To(BeFalse())
+			Expect(p.GetFirstIndex()).To(Equal(uint64(2)))
+			Expect(p.GetLastIndex()).To(Equal(uint64(2000)))
+
+			for i := uint64(2); i <= 2000; i += 2 {
+				Expect(p.Read(i, func(data []byte) error {
+					Expect(data).To(Equal(payload(i)))
+					return nil
+				})).To(Succeed())
+			}
+
+			Expect(p.Read(3, func([]byte) error { return nil })).To(MatchError(statemate.ErrNotFound))
+			Expect(p.Read(2002, func([]byte) error { return nil })).To(MatchError(statemate.ErrNotFound))
+		})
+
+		It("should be smaller than the store", func() {
+			Expect(statemate.Pack(fileName, packedFileName, statemate.PackOptions{})).To(Succeed())
+
+			stored, err := os.Stat(fileName)
+			Expect(err).ToNot(HaveOccurred())
+			packed, err := os.Stat(packedFileName)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(packed.Size()).To(BeNumerically("<", stored.Size()/2))
+		})
+
+		It("should decompress only one block to read an entry", func() {
+			Expect(statemate.Pack(fileName, packedFileName, statemate.PackOptions{BlockSize: 1024})).To(Succeed())
+
+			codec := countingCodec{Codec: statemate.Zstd, decompressed: &atomic.Int64{}}
+			p := openPacked(statemate.Options{Codec: codec})
+
+			Expect(p.Read(1000, func(data []byte) error {
+				Expect(data).To(Equal(payload(1000)))
+				return nil
+			})).To(Succeed())
+			Expect(codec.decompressed.Load()).To(Equal(int64(1)))
+		})
+
+		It("should decompress every block once when ranging", func() {
+			Expect(statemate.Pack(fileName, packedFileName, statemate.PackOptions{BlockSize: 1024})).To(Succeed())
+
+			codec := countingCodec{Codec: statemate.Zstd, decompressed: &atomic.Int64{}}
+			p := openPacked(statemate.Options{Codec: codec})
+
+			indices := []uint64{}
+			Expect(p.Range(101, 1500, func(index uint64, data []byte) error {
+				Expect(data).To(Equal(payload(index)))
+				indices = append(indices, index)
+				return nil
+			})).To(Succeed())
+			Expect(indices).To(HaveLen(700))
+			Expect(indices[0]).To(Equal(uint64(102)))
+
+			ranged := codec.decompressed.Load()
+			Expect(ranged).To(BeNumerically(">", 1))
+
+			count := 0
+			for index, data := range p.All() {
+				Expect(data).To(Equal(payload(index)))
+				count++
+			}
+			Expect(count).To(Equal(1000))
+			Expect(codec.decompressed.Load() - ranged).To(BeNumerically("<", 1000))
+		})
+
+		It("should store entries larger than the block size", func() {
+			Expect(statemate.Pack(fileName, packedFileName, statemate.PackOptions{BlockSize: 16})).To(Succeed())
+
+			p := openPacked(statemate.Options{})
+			Expect(p.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+				Expect(data).To(Equal(payload(index)))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should pack with a custom block codec", func() {
+			Expect(statemate.Pack(fileName, packedFileName, statemate.PackOptions{BlockCodec: reverseCodec{}})).To(Succeed())
+
+			_, err := statemate.OpenPacked[uint64](packedFileName, statemate.Options{})
+			Expect(err).To(MatchError(statemate.ErrUnsupportedFormat))
+
+			p := openPacked(statemate.Options{Codec: reverseCodec{}})
+			Expect(p.Read(42, func(data []byte) error {
+				Expect(data).To(Equal(payload(42)))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should pack an empty store", func() {
+			emptyFileName := filepath.Join(tempDir, "empty")
+			sm, err := statemate.Open[uint64](emptyFileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sm.Close()).To(Succeed())
+
+			Expect(statemate.Pack(emptyFileName, packedFileName, statemate.PackOptions{})).To(Succeed())
+
+			p := openPacked(statemate.Options{})
+			Expect(p.IsEmpty()).To(BeTrue())
+			Expect(p.GetFirstIndex()).To(Equal(uint64(math.MaxUint64)))
+			Expect(p.Read(1, func([]byte) error { return nil })).To(MatchError(statemate.ErrNotFound))
+		})
+
+		It("should detect corrupted blocks", func() {
+			Expect(statemate.Pack(fileName, packedFileName, statemate.PackOptions{})).To(Succeed())
+
+			f, err := os.OpenFile(packedFileName, os.O_RDWR, 0)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = f.WriteAt(bytes.Repeat([]byte{0xff}, 8), 72)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+
+			p := openPacked(statemate.Options{})
+			Expect(p.Read(2, func([]byte) error { return nil })).ToNot(Succeed())
+
+			for range p.All() {
+				Fail("yielded an entry of a corrupted block")
+			}
+
+			var iterErr error
+			for e, err := range p.Entries(0, math.MaxUint64) {
+				Expect(e.Data).To(BeNil())
+				iterErr = err
+			}
+			Expect(iterErr).To(HaveOccurred())
+		})
+
+		It("should return ErrClosed after Close", func() {
+			Expect(statemate.Pack(fileName, packedFileName, statemate.PackOptions{})).To(Succeed())
+
+			p, err := statemate.OpenPacked[uint64](packedFileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.Close()).To(Succeed())
+
+			Expect(p.Read(2, func([]byte) error { return nil })).To(MatchError(statemate.ErrClosed))
+		})
+	})
+
+	Describe("encryption", func() {
+		var fileName string
+		var key []byte
+
+		BeforeEach(func() {
+			fileName = filepath.Join(tempDir, "state")
+			key = bytes.Repeat([]byte{42}, 32)
+		})
+
+		openEncryptedFile := func(name string, key []byte, options statemate.Options) *statemate.StateMate[uint64] {
+			c, err := statemate.NewAESGCM(key)
+			Expect(err).ToNot(HaveOccurred())
+
+			options.Cipher = c
+			sm, err := statemate.Open[uint64](name, options)
+			Expect(err).ToNot(HaveOccurred())
+			return sm
+		}
+
+		openEncrypted := func(key []byte, options statemate.Options) *statemate.StateMate[uint64] {
+			return openEncryptedFile(fileName, key, options)
+		}
+
+		secret := func(index uint64) []byte {
+			return []byte(fmt.Sprintf("customer secret %d", index))
+		}
+
+		// storedFileData returns the stored data of the entries from the data file, which all have the same size:
+		// the key ID, the encrypted secret and the authentication tag.
+		storedFileData := func(name string, index uint64) []byte {
+			d, err := os.ReadFile(name)
+			Expect(err).ToNot(HaveOccurred())
+
+			size := 16 + len(secret(0)) + 16
+			start := 16 + int(index-1)*size
+			return d[start : start+size]
+		}
+
+		storedData := func(index uint64) []byte {
+			return storedFileData(fileName, index)
+		}
+
+		// expectIndependentEncryption expects two stored entries of the same index to be encrypted with different keys:
+		// with the same key and nonce the XOR of the encrypted data would be the XOR of the secrets.
+		expectIndependentEncryption := func(a, b []byte, secretA, secretB []byte) {
+			Expect(a[:16]).ToNot(Equal(b[:16]))
+
+			encryptedXOR := make([]byte, len(secretA))
+			secretXOR := make([]byte, len(secretA))
+			for i := range secretA {
+				encryptedXOR[i] = a[16+i] ^ b[16+i]
+				secretXOR[i] = secretA[i] ^ secretB[i]
+			}
+			Expect(encryptedXOR).ToNot(Equal(secretXOR))
+		}
+
+		Context("when entries have been appended", func() {
+			BeforeEach(func() {
+				sm := openEncrypted(key, statemate.Options{})
+				for i := uint64(1); i <= 9; i++ {
+					Expect(sm.Append(i, secret(i))).To(Succeed())
+				}
+				Expect(sm.Close()).To(Succeed())
+			})
+
+			It("should not store the data in plain text", func() {
+				d, err := os.ReadFile(fileName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(bytes.Contains(d, []byte("customer secret"))).To(BeFalse())
+			})
+
+			It("should decrypt the entries", func() {
+				sm := openEncrypted(key, statemate.Options{})
+				defer sm.Close()
+
+				Expect(sm.Read(5, func(data []byte) error {
+					Expect(data).To(Equal(secret(5)))
+					return nil
+				})).To(Succeed())
+
+				Expect(sm.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+					Expect(data).To(Equal(secret(index)))
+					return nil
+				})).To(Succeed())
+
+				count := 0
+				for index, data := range sm.Backward() {
+					Expect(data).To(Equal(secret(index)))
+					count++
+				}
+				Expect(count).To(Equal(9))
+			})
+
+			It("should return ErrDecryptionFailed with a different key", func() {
+				sm := openEncrypted(bytes.Repeat([]byte{7}, 32), statemate.Options{})
+				defer sm.Close()
+
+				Expect(sm.Read(5, func([]byte) error { return nil })).To(MatchError(statemate.ErrDecryptionFailed))
+			})
+
+			It("should return ErrDecryptionFailed for modified data", func() {
+				f, err := os.OpenFile(fileName, os.O_RDWR, 0)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = f.WriteAt([]byte{0xff}, 16+16+1)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				sm := openEncrypted(key, statemate.Options{})
+				defer sm.Close()
+
+				Expect(sm.Read(1, func([]byte) error { return nil })).To(MatchError(statemate.ErrDecryptionFailed))
+				Expect(sm.Read(2, func([]byte) error { return nil })).To(Succeed())
+			})
+
+			It("should not decrypt an entry as a different index", func() {
+				first := storedData(1)
+
+				f, err := os.OpenFile(fileName, os.O_RDWR, 0)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = f.WriteAt(first, 16+int64(len(first)))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				sm, err := statemate.OpenReadOnly[uint64](fileName, statemate.Options{Cipher: must(statemate.NewAESGCM(key))})
+				Expect(err).ToNot(HaveOccurred())
+				defer sm.Close()
+
+				Expect(sm.Read(2, func([]byte) error { return nil })).To(MatchError(statemate.ErrDecryptionFailed))
+			})
+
+			It("should stop iterating at modified data and report the error", func() {
+				f, err := os.OpenFile(fileName, os.O_RDWR, 0)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = f.WriteAt([]byte{0xff}, 16+int64(len(storedData(1)))*2+16+1)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				sm := openEncrypted(key, statemate.Options{})
+				defer sm.Close()
+
+				indices := []uint64{}
+				var iterErr error
+				for e, err := range sm.Entries(0, math.MaxUint64) {
+					indices = append(indices, e.Index)
+					iterErr = err
+				}
+				Expect(indices).To(Equal([]uint64{1, 2, 3}))
+				Expect(iterErr).To(MatchError(statemate.ErrDecryptionFailed))
+			})
+
+			It("should encrypt independently from a backup appended to with the same key", func() {
+				backupName := filepath.Join(tempDir, "backup")
+
+				sm := openEncrypted(key, statemate.Options{})
+				defer sm.Close()
+				Expect(sm.Backup(context.Background(), backupName)).To(Succeed())
+
+				backup := openEncryptedFile(backupName, key, statemate.Options{})
+				defer backup.Close()
+
+				Expect(sm.Append(10, secret(10))).To(Succeed())
+				Expect(sm.Sync()).To(Succeed())
+				Expect(backup.Append(10, secret(20))).To(Succeed())
+				Expect(backup.Sync()).To(Succeed())
+
+				expectIndependentEncryption(storedData(10), storedFileData(backupName, 10), secret(10), secret(20))
+			})
+
+			It("should not pack the entries", func() {
+				err := statemate.Pack(fileName, filepath.Join(tempDir, "packed"), statemate.PackOptions{
+					Options: statemate.Options{Cipher: must(statemate.NewAESGCM(key))},
+				})
+				Expect(err).To(MatchError(statemate.ErrUnsupportedFormat))
+				Expect(filepath.Join(tempDir, "packed")).ToNot(BeAnExistingFile())
+			})
+
+			It("should return ErrNoCipher without a cipher", func() {
+				sm, err := statemate.Open[uint64](fileName, statemate.Options{})
+				Expect(err).ToNot(HaveOccurred())
+				defer sm.Close()
+
+				Expect(sm.Count()).To(Equal(uint64(9)))
+				Expect(sm.Read(5, func([]byte) error { return nil })).To(MatchError(statemate.ErrNoCipher))
+				Expect(sm.Append(10, secret(10))).To(MatchError(statemate.ErrNoCipher))
+			})
+
+			It("should encrypt entries appended again after TruncateAfter with a new key", func() {
+				sm := openEncrypted(key, statemate.Options{})
+				defer sm.Close()
+
+				Expect(sm.Append(10, secret(10))).To(Succeed())
+				Expect(sm.Sync()).To(Succeed())
+				removed := storedData(10)
+
+				Expect(sm.TruncateAfter(9)).To(Succeed())
+				Expect(sm.Append(10, secret(20))).To(Succeed())
+				Expect(sm.Sync()).To(Succeed())
+
+				expectIndependentEncryption(storedData(10), removed, secret(20), secret(10))
+			})
+
+			It("should encrypt entries appended again after a rollback with a new key", func() {
+				sm := openEncrypted(key, statemate.Options{})
+				defer sm.Close()
+
+				tx, err := sm.Begin()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.Append(10, secret(10))).To(Succeed())
+				Expect(sm.Sync()).To(Succeed())
+				rolledBack := storedData(10)
+				Expect(tx.Rollback()).To(Succeed())
+
+				Expect(sm.Append(10, secret(10))).To(Succeed())
+				Expect(sm.Sync()).To(Succeed())
+
+				Expect(storedData(10)[:16]).ToNot(Equal(rolledBack[:16]))
+
+				Expect(sm.Range(0, math.MaxUint64, func(index uint64, data []byte) error {
+					Expect(data).To(Equal(secret(index)))
+					return nil
+				})).To(Succeed())
+			})
+		})
+
+		It("should encrypt the same index of stores sharing a key independently", func() {
+			otherName := filepath.Join(tempDir, "other")
+
+			sm := openEncrypted(key, statemate.Options{})
+			defer sm.Close()
+			other := openEncryptedFile(otherName, key, statemate.Options{})
+			defer other.Close()
+
+			Expect(sm.Append(1, secret(1))).To(Succeed())
+			Expect(sm.Sync()).To(Succeed())
+			Expect(other.Append(1, secret(2))).To(Succeed())
+			Expect(other.Sync()).To(Succeed())
+
+			expectIndependentEncryption(storedData(1), storedFileData(otherName, 1), secret(1), secret(2))
+		})
+
+		It("should compress the entries before encrypting them", func() {
+			sm := openEncrypted(key, statemate.Options{Codec: statemate.Zstd})
+			defer sm.Close()
+
+			data := bytes.Repeat(secret(1), 100)
+			Expect(sm.Append(1, data)).To(Succeed())
+			Expect(sm.StorageStats().DataSize).To(BeNumerically("<", len(data)/5))
+
+			Expect(sm.Read(1, func(d []byte) error {
+				Expect(d).To(Equal(data))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should return ErrNotEncrypted when opening a store that is not encrypted with a cipher", func() {
+			sm, err := statemate.Open[uint64](fileName, statemate.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sm.Close()).To(Succeed())
+
+			_, err = statemate.Open[uint64](fileName, statemate.Options{Cipher: must(statemate.NewAESGCM(key))})
+			Expect(err).To(MatchError(statemate.ErrNotEncrypted))
+		})
+
+		It("should not allow dictionary compression", func() {
+			_, err := statemate.Open[uint64](fileName, statemate.Options{
+				Cipher: must(statemate.NewAESGCM(key)),
+				Codec:  statemate.ZstdDictionary,
+			})
+			Expect(err).To(MatchError(statemate.ErrUnsupportedFormat))
+		})
+	})
 })
@@ -0,0 +1,138 @@
+package statemate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SyncMode controls when appended entries are flushed to disk.
+type SyncMode int
+
+const (
+	// SyncNone never flushes explicitly and leaves writing back the data to the operating system.
+	// The count in the index header is updated on every append.
+	SyncNone SyncMode = iota
+	// SyncEveryAppend flushes data and index on every Append and AppendBatch call.
+	SyncEveryAppend
+	// SyncEveryN flushes after at least Options.SyncEvery entries have been appended since the last flush.
+	SyncEveryN
+	// SyncInterval flushes Options.SyncInterval after the first entry appended since the last flush.
+	SyncInterval
+	// SyncManual only flushes when Sync or Close is called.
+	SyncManual
+)
+
+var ErrClosed = errors.New("closed")
+
+// Sync flushes the data and index files to disk and updates the count in the index header.
+// The data is always flushed before the count is updated, so after a crash
+// the header never points at entries that were not written.
+// Except in SyncNone mode, entries appended after the last flush are not persisted in the header
+// and are lost after a crash.
+// Sync does nothing for read-only stores and followers.
+func (sm *StateMate[T]) Sync() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.closed {
+		return ErrClosed
+	}
+
+	if sm.readOnly {
+		return nil
+	}
+
+	return errors.Join(sm.takeSyncError(), sm.sync())
+}
+
+// commit makes count entries visible and persists them according to the sync mode.
+// When the entries have to be flushed, they are only made visible after the flush succeeded,
+// so a failed flush leaves the store unchanged and the append can be retried.
+func (sm *StateMate[T]) commit(count uint64) error {
+	switch sm.options.Sync {
+	case SyncEveryAppend:
+		err := sm.syncCount(count)
+		if err != nil {
+			return err
+		}
+	case SyncEveryN:
+		if count-sm.persistedCount >= sm.options.SyncEvery {
+			err := sm.syncCount(count)
+			if err != nil {
+				return err
+			}
+		}
+	case SyncInterval:
+		if sm.syncTimer == nil {
+			sm.syncTimer = time.AfterFunc(sm.options.SyncInterval, sm.backgroundSync)
+		}
+	case SyncManual:
+	default:
+		sm.setHeaderCount(count)
+		sm.persistedCount = count
+	}
+
+	sm.count = count
+	sm.notifyAppended()
+
+	return nil
+}
+
+func (sm *StateMate[T]) backgroundSync() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.syncTimer = nil
+
+	if sm.closed {
+		return
+	}
+
+	sm.syncErr = errors.Join(sm.syncErr, sm.sync())
+}
+
+func (sm *StateMate[T]) takeSyncError() error {
+	err := sm.syncErr
+	sm.syncErr = nil
+	if err != nil {
+		return fmt.Errorf("background sync failed: %w", err)
+	}
+	return nil
+}
+
+// sync flushes the data and the index records before updating and flushing the count in the index header.
+func (sm *StateMate[T]) sync() error {
+	return sm.syncCount(sm.count)
+}
+
+// syncCount flushes the first count entries and persists count in the index header.
+// When flushing fails, the header keeps the previously persisted count.
+func (sm *StateMate[T]) syncCount(count uint64) error {
+	if sm.syncTimer != nil {
+		sm.syncTimer.Stop()
+		sm.syncTimer = nil
+	}
+
+	err := sm.data.Flush()
+	if err != nil {
+		return fmt.Errorf("could not flush data mmap: %w", err)
+	}
+
+	err = sm.index.Flush()
+	if err != nil {
+		return fmt.Errorf("could not flush index mmap: %w", err)
+	}
+
+	sm.setHeaderCount(count)
+
+	err = sm.index.Flush()
+	if err != nil {
+		sm.setHeaderCount(sm.persistedCount)
+		return fmt.Errorf("could not flush index header: %w", err)
+	}
+
+	sm.persistedCount = count
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package statemate
+
+import "errors"
+
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// Tx is a write transaction staging entries that become visible together on Commit.
+// While a transaction is open, all other writers of the store are blocked, readers are not.
+// A Tx must not be used concurrently and must always be finished with Commit or Rollback.
+type Tx[T ~uint64] struct {
+	sm *StateMate[T]
+	// count is the number of entries in the store including the staged ones.
+	count uint64
+	done  bool
+}
+
+// Begin starts a write transaction, waiting until other writers and transactions are finished.
+func (sm *StateMate[T]) Begin() (*Tx[T], error) {
+	if sm.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	sm.writeMu.Lock()
+
+	sm.mu.RLock()
+	closed := sm.closed
+	count := sm.count
+	sm.mu.RUnlock()
+
+	if closed {
+		sm.writeMu.Unlock()
+		return nil, ErrClosed
+	}
+
+	return &Tx[T]{
+		sm:    sm,
+		count: count,
+	}, nil
+}
+
+// Append stages the entry.
+// Its data and index record are written beyond the entries visible to readers,
+// so they are ignored after a crash unless the transaction has been committed.
+// When Append returns an error, the entry is not staged and the transaction can still be used.
+func (tx *Tx[T]) Append(index T, data []byte) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	sm := tx.sm
+
+	entries, err := sm.encode([]Entry[T]{{Index: index, Data: data}})
+	if err != nil {
+		return err
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.closed {
+		return ErrClosed
+	}
+
+	err = sm.stage(tx.count, entries)
+	if err != nil {
+		return err
+	}
+
+	tx.count++
+
+	return nil
+}
+
+// Commit makes all staged entries visible at once by updating the entry count,
+// which is persisted according to the sync mode of the store.
+func (tx *Tx[T]) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.done = true
+
+	sm := tx.sm
+	defer sm.writeMu.Unlock()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.closed {
+		return ErrClosed
+	}
+
+	if tx.count == sm.count {
+		return nil
+	}
+
+	return sm.commit(tx.count)
+}
+
+// Rollback discards all staged entries, their space is reused by subsequent appends.
+func (tx *Tx[T]) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.done = true
+	tx.sm.writeMu.Unlock()
+
+	return nil
+}